@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+
+	"github.com/lukehoban/icfp2020/physics"
+)
+
+// Bot plays the alien game: it chooses the parameters of its first ship and
+// then, every tick, the commands for its ships.
+type Bot interface {
+	// Start returns the parameters of the first ship, within info.Limits.
+	Start(info GameInfo) ShipParams
+	// Commands returns the commands of the bot's ships for the next tick.
+	Commands(info GameInfo, state *GameState) []Command
+}
+
+// bots are the reference bots by name. Each is created with the random
+// source of the match, so that a match is reproducible from its seed.
+var bots = map[string]func(rng *rand.Rand) Bot{
+	"idle":    func(*rand.Rand) Bot { return idleBot{} },
+	"orbit":   func(rng *rand.Rand) Bot { return &orbitBot{rng: rng} },
+	"shooter": func(rng *rand.Rand) Bot { return &shooterBot{orbitBot{rng: rng}} },
+}
+
+func botNames() []string {
+	names := make([]string, 0, len(bots))
+	for name := range bots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newBot(name string, rng *rand.Rand) (Bot, error) {
+	mk, ok := bots[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown bot %q, want one of %s", name, strings.Join(botNames(), ", "))
+	}
+	return mk(rng), nil
+}
+
+// withFuel fills up params with as much fuel as the cost limit leaves.
+func withFuel(info GameInfo, params ShipParams) ShipParams {
+	if len(info.Limits) > 0 {
+		params.Fuel = max(0, info.Limits[0]-paramsCost(params))
+	}
+	return params
+}
+
+// idleBot starts with nothing but a hull and never does anything.
+type idleBot struct{}
+
+func (idleBot) Start(info GameInfo) ShipParams {
+	return ShipParams{Copies: 1}
+}
+
+func (idleBot) Commands(GameInfo, *GameState) []Command {
+	return nil
+}
+
+// orbitHorizon is how many ticks ahead orbitBot checks that its ships stay
+// clear of the planet and within the safe zone.
+const orbitHorizon = 32
+
+// orbitBot spends all its resources on fuel and thrusts whenever a ship's
+// course would hit the planet or leave the safe zone, picking the thrust
+// that keeps the ship flying longest.
+type orbitBot struct {
+	rng *rand.Rand
+}
+
+func (b *orbitBot) Start(info GameInfo) ShipParams {
+	return withFuel(info, ShipParams{Copies: 1})
+}
+
+func (b *orbitBot) Commands(info GameInfo, state *GameState) []Command {
+	var commands []Command
+	for _, ship := range state.ShipsOf(info.Role) {
+		if thrust, ok := b.thrust(info, ship); ok {
+			commands = append(commands, Accelerate{ShipID: ship.ID, Vector: thrust})
+		}
+	}
+	return commands
+}
+
+// thrust returns the acceleration that keeps ship flying longest, or false
+// when it is safe without one or has no fuel left.
+func (b *orbitBot) thrust(info GameInfo, ship Ship) (Vec, bool) {
+	rules, s := info.rules(), ship.physics()
+	if ship.Params.Fuel <= 0 || rules.Survival(s, physics.Vec{}, orbitHorizon) >= orbitHorizon {
+		return Vec{}, false
+	}
+	best, bestTicks := []Vec(nil), -1
+	for dx := int64(-1); dx <= 1; dx++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			v := Vec{X: dx, Y: dy}
+			switch ticks := rules.Survival(s, physics.Vec(v), orbitHorizon); {
+			case ticks > bestTicks:
+				best, bestTicks = []Vec{v}, ticks
+			case ticks == bestTicks:
+				best = append(best, v)
+			}
+		}
+	}
+	return best[b.rng.IntN(len(best))], true
+}
+
+// shooterBot keeps its ships in orbit like orbitBot and fires its lasers
+// at the nearest enemy ship every tick, as hard as its heat allows.
+type shooterBot struct {
+	orbitBot
+}
+
+func (b *shooterBot) Start(info GameInfo) ShipParams {
+	laser, cooling := int64(48), int64(8)
+	if len(info.Limits) > 2 {
+		laser, cooling = min(laser, info.Limits[1]), min(cooling, info.Limits[2])
+	}
+	return withFuel(info, ShipParams{Laser: laser, Cooling: cooling, Copies: 1})
+}
+
+func (b *shooterBot) Commands(info GameInfo, state *GameState) []Command {
+	var commands []Command
+	enemies := state.ShipsOf(1 - info.Role)
+	for _, ship := range state.ShipsOf(info.Role) {
+		// Keep the heat of the shot and of any thrust under the maximum, so
+		// that it never burns the ship's own fuel.
+		headroom := ship.MaxHeat - ship.Heat + ship.Params.Cooling
+		if thrust, ok := b.thrust(info, ship); ok {
+			commands = append(commands, Accelerate{ShipID: ship.ID, Vector: thrust})
+			headroom -= physics.AccelerateHeat * physics.Norm(physics.Vec(thrust))
+		}
+		power := min(ship.Params.Laser, headroom)
+		if power <= 0 || len(enemies) == 0 {
+			continue
+		}
+		target := nearest(ship.Position, enemies).physics()
+		// Shots hit after the ships moved, so aim where the target will be.
+		aim := target.Position.Add(target.Velocity).Add(info.rules().Gravity(target.Position))
+		if physics.ShotDamage(physics.Vec(ship.Position), aim, power) > 0 {
+			commands = append(commands, Shoot{ShipID: ship.ID, Target: Vec(aim), Power: power})
+		}
+	}
+	return commands
+}
+
+// nearest returns the ship closest to p, the first one on ties.
+func nearest(p Vec, ships []Ship) Ship {
+	best := ships[0]
+	for _, s := range ships[1:] {
+		if physics.Distance(physics.Vec(p), physics.Vec(s.Position)) < physics.Distance(physics.Vec(p), physics.Vec(best.Position)) {
+			best = s
+		}
+	}
+	return best
+}
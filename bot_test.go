@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrbitBotThrustsOnlyWhenNeeded(t *testing.T) {
+	info := GameInfo{Role: RoleDefender, Limits: []int64{448, 64, 128}, Planet: &PlanetInfo{Radius: 16, SafeRadius: 128}}
+	bot, err := newBot("orbit", rand.New(rand.NewPCG(1, 1)))
+	require.NoError(t, err)
+	assert.Equal(t, ShipParams{Fuel: 446, Copies: 1}, bot.Start(info))
+
+	falling := Ship{Role: RoleDefender, ID: 3, Position: Vec{X: 48, Y: 48}, Params: ShipParams{Fuel: 10}}
+	commands := bot.Commands(info, &GameState{Ships: []ShipState{{Ship: falling}}})
+	require.Len(t, commands, 1)
+	assert.Equal(t, int64(3), commands[0].(Accelerate).ShipID)
+
+	orbiting := falling
+	orbiting.Velocity = Vec{X: 6, Y: -6}
+	assert.Empty(t, bot.Commands(info, &GameState{Ships: []ShipState{{Ship: orbiting}}}))
+
+	falling.Params.Fuel = 0
+	assert.Empty(t, bot.Commands(info, &GameState{Ships: []ShipState{{Ship: falling}}}))
+}
+
+func TestShooterBotAimsAtNearestEnemy(t *testing.T) {
+	info := GameInfo{Role: RoleAttacker, Limits: []int64{512, 64, 128}, Planet: &PlanetInfo{Radius: 16, SafeRadius: 128}}
+	bot, err := newBot("shooter", rand.New(rand.NewPCG(1, 1)))
+	require.NoError(t, err)
+	params := bot.Start(info)
+	assert.Equal(t, ShipParams{Fuel: 222, Laser: 48, Cooling: 8, Copies: 1}, params)
+	assert.LessOrEqual(t, paramsCost(params), info.Limits[0])
+
+	me := Ship{Role: RoleAttacker, ID: 0, Position: Vec{X: -48, Y: 48}, Velocity: Vec{X: 6, Y: 6}, Params: params, Heat: 30, MaxHeat: 64}
+	near := Ship{Role: RoleDefender, ID: 1, Position: Vec{X: 48, Y: 40}, Velocity: Vec{X: -2}}
+	far := Ship{Role: RoleDefender, ID: 2, Position: Vec{X: 100, Y: 0}}
+	commands := bot.Commands(info, &GameState{Ships: []ShipState{{Ship: me}, {Ship: near}, {Ship: far}}})
+	assert.Equal(t, []Command{Shoot{ShipID: 0, Target: Vec{X: 45, Y: 40}, Power: 42}}, commands)
+
+	// Too hot for a shot to reach that far.
+	me.Heat = 60
+	assert.Empty(t, bot.Commands(info, &GameState{Ships: []ShipState{{Ship: me}, {Ship: near}, {Ship: far}}}))
+}
+
+func TestNewBotUnknown(t *testing.T) {
+	_, err := newBot("clever", nil)
+	assert.EqualError(t, err, `unknown bot "clever", want one of idle, orbit, shooter`)
+}
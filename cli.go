@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const cliUsage = `usage: galaxy <command> [flags] [args]
+
+Commands:
+  serve      run the HTTP interpreter (the default when no command is given)
+  eval       evaluate an expression and print its value
+  interact   call galaxy with a state and point and print the JSON response
+  render     call galaxy and draw the resulting images as text or PNG
+  check      parse a program and report undefined symbols
+  repl       start an interactive session
+  tournament play reference bots of the alien game against each other
+
+Run "galaxy <command> -h" for the flags of a command. Flags default to the
+GALAXY_ADDR, GALAXY_PROGRAM, GALAXY_TIMEOUT, GALAXY_LOG_LEVEL,
+GALAXY_LOG_FORMAT, GALAXY_WATCH, GALAXY_SEND_URL, GALAXY_SEND_REPLAY, GALAXY_SEND_RECORD, GALAXY_SEND_FAULTS,
+GALAXY_INTERN, GALAXY_CACHE_SIZE, GALAXY_CACHE_TTL, GALAXY_MAX_HEAP_MB,
+GALAXY_MAX_BODY_BYTES, GALAXY_RATE, GALAXY_BURST, GALAXY_MAX_EVALS,
+GALAXY_EVAL_QUEUE, GALAXY_QUEUE_WAIT, GALAXY_STEP_RATE, GALAXY_STEP_BURST,
+GALAXY_READ_TIMEOUT, GALAXY_WRITE_TIMEOUT, GALAXY_IDLE_TIMEOUT,
+GALAXY_SHUTDOWN_TIMEOUT, GALAXY_TLS_CERT, GALAXY_TLS_KEY, GALAXY_STATE_FILE and
+GALAXY_READY_TIMEOUT environment variables when they are set.`
+
+// config holds the settings shared by the subcommands.
+type config struct {
+	addr        string
+	programPath string
+	timeout     time.Duration
+	logLevel    string
+	logFormat   string
+	sendURL     string
+	sendReplay  string
+	sendRecord  string
+	sendFaults  string
+	intern      bool
+	// sends is set when the send flags were added, which is when apply
+	// builds the sender.
+	sends bool
+}
+
+func envOr(name, fallback string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return fallback
+}
+
+func envInt(name string, fallback int64) int64 {
+	n, err := strconv.ParseInt(envOr(name, ""), 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+func envFloat(name string, fallback float64) float64 {
+	f, err := strconv.ParseFloat(envOr(name, ""), 64)
+	if err != nil {
+		return fallback
+	}
+	return f
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(envOr(name, ""))
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+// newFlagSet creates the flags shared by the subcommands, with defaults
+// taken from the environment.
+func newFlagSet(name string, cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.programPath, "program", envOr("GALAXY_PROGRAM", "galaxy.txt"), "path of the program to load (GALAXY_PROGRAM)")
+	timeout, err := time.ParseDuration(envOr("GALAXY_TIMEOUT", "0s"))
+	if err != nil {
+		timeout = 0
+	}
+	fs.DurationVar(&cfg.timeout, "timeout", timeout, "maximum duration of a single evaluation, 0 for none, or for serve most of -write-timeout (GALAXY_TIMEOUT)")
+	fs.StringVar(&cfg.logLevel, "log-level", envOr("GALAXY_LOG_LEVEL", "info"), "one of debug, info, warn, error (GALAXY_LOG_LEVEL)")
+	fs.StringVar(&cfg.logFormat, "log-format", envOr("GALAXY_LOG_FORMAT", "text"), "text, or json for one JSON object per line (GALAXY_LOG_FORMAT)")
+	return fs
+}
+
+// internFlag adds -intern, for the subcommands that evaluate against the
+// loaded program.
+func (cfg *config) internFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&cfg.intern, "intern", envOr("GALAXY_INTERN", "") == "1", "hash-cons expressions to share structure, 1 to enable (GALAXY_INTERN)")
+}
+
+// sendFlags adds the flags that say where interactions send data, for the
+// subcommands that run the interaction protocol.
+func (cfg *config) sendFlags(fs *flag.FlagSet) {
+	cfg.sends = true
+	fs.StringVar(&cfg.sendURL, "send-url", envOr("GALAXY_SEND_URL", ""), "URL that data galaxy sends is POSTed to, or standin for the built-in game server; interactions stop at the first send when empty (GALAXY_SEND_URL)")
+	fs.StringVar(&cfg.sendReplay, "send-replay", envOr("GALAXY_SEND_REPLAY", ""), "answer sends from a log written with -send-record instead of -send-url (GALAXY_SEND_REPLAY)")
+	fs.StringVar(&cfg.sendRecord, "send-record", envOr("GALAXY_SEND_RECORD", ""), "append every send and its response to this log (GALAXY_SEND_RECORD)")
+	fs.StringVar(&cfg.sendFaults, "send-faults", envOr("GALAXY_SEND_FAULTS", ""), "make sends flaky, e.g. latency=200ms,errors=0.1,garble=0.001,seed=1 (GALAXY_SEND_FAULTS)")
+}
+
+// apply validates cfg, installs its global settings and loads the program.
+func (cfg *config) apply() error {
+	if err := setupLogging(os.Stderr, cfg.logLevel, cfg.logFormat); err != nil {
+		return err
+	}
+	evalTimeout = cfg.timeout
+	if cfg.sends {
+		sender, err := newSender(cfg.sendURL, cfg.sendReplay, cfg.sendRecord, cfg.sendFaults)
+		if err != nil {
+			return err
+		}
+		sendToAliens = sender
+	}
+	programs.intern = cfg.intern
+	return loadProgram(cfg.programPath)
+}
+
+// context returns the context for an evaluation against the default
+// program.
+func (cfg *config) context() (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if p, ok := programs.get(defaultProgram); ok {
+		ctx = p.context(ctx)
+	}
+	if cfg.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.timeout)
+}
+
+// runCLI runs the command args select. Asking a command for its flags with
+// -h succeeds.
+func runCLI(args []string) error {
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	if err := runCommand(cmd, args); !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
+}
+
+func runCommand(cmd string, args []string) error {
+	switch cmd {
+	case "serve":
+		return runServe(args)
+	case "eval":
+		return runEval(args, os.Stdin, os.Stdout, os.Stderr)
+	case "interact":
+		return runInteract(args, os.Stdout)
+	case "render":
+		return runRender(args, os.Stdout)
+	case "check":
+		return runCheck(args, os.Stdout)
+	case "repl":
+		return runREPL(args)
+	case "tournament":
+		return runTournament(args, os.Stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Println(cliUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, cliUsage)
+	}
+}
+
+func runServe(args []string) error {
+	var cfg config
+	fs := newFlagSet("serve", &cfg)
+	cfg.internFlag(fs)
+	cfg.sendFlags(fs)
+	fs.StringVar(&cfg.addr, "addr", envOr("GALAXY_ADDR", ":8080"), "address to listen on (GALAXY_ADDR)")
+	extra := programFlag{}
+	fs.Var(extra, "load", "additional program to serve as name=path; may be repeated")
+	watch, err := time.ParseDuration(envOr("GALAXY_WATCH", "2s"))
+	if err != nil {
+		watch = 0
+	}
+	fs.DurationVar(&watch, "watch", watch, "how often to check program files for changes, 0 to disable (GALAXY_WATCH)")
+	cacheSize, err := strconv.Atoi(envOr("GALAXY_CACHE_SIZE", strconv.Itoa(defaultCacheSize)))
+	if err != nil {
+		cacheSize = defaultCacheSize
+	}
+	fs.IntVar(&cacheSize, "cache-size", cacheSize, "number of interaction results to cache, 0 to disable (GALAXY_CACHE_SIZE)")
+	cacheTTL, err := time.ParseDuration(envOr("GALAXY_CACHE_TTL", defaultCacheTTL.String()))
+	if err != nil {
+		cacheTTL = defaultCacheTTL
+	}
+	fs.DurationVar(&cacheTTL, "cache-ttl", cacheTTL, "how long cached interaction results stay valid, 0 for no limit (GALAXY_CACHE_TTL)")
+	maxHeap, err := strconv.ParseUint(envOr("GALAXY_MAX_HEAP_MB", "0"), 10, 64)
+	if err != nil {
+		maxHeap = 0
+	}
+	fs.Uint64Var(&maxHeap, "max-heap-mb", maxHeap, "heap size in MiB above which cached evaluation results are dropped, 0 for no limit (GALAXY_MAX_HEAP_MB)")
+	fs.DurationVar(&readyTimeout, "ready-timeout", envDuration("GALAXY_READY_TIMEOUT", defaultReadyTimeout), "maximum duration of the smoke test of /readyz (GALAXY_READY_TIMEOUT)")
+	limits := limitFlags(fs)
+	srvCfg := serverFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (srvCfg.tlsCert == "") != (srvCfg.tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+	if evalTimeout <= 0 {
+		evalTimeout = srvCfg.defaultEvalTimeout()
+	}
+	interactResults.configure(cacheSize, cacheTTL)
+	requestLimits.configure(*limits)
+	for name, path := range extra {
+		if _, err := programs.load(name, path); err != nil {
+			return fmt.Errorf("failed to load program %s: %w", name, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if watch > 0 {
+		go programs.watch(ctx, watch)
+	}
+	if maxHeap > 0 {
+		heapLimit = maxHeap << 20
+		go programs.watchMemory(ctx, heapLimit)
+	}
+
+	ln, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("server starting", "addr", ln.Addr().String())
+	return runServer(ctx, ln, newMux(), *srvCfg)
+}
+
+// serverFlags adds the flags that configure the HTTP server of serve.
+func serverFlags(fs *flag.FlagSet) *serverConfig {
+	cfg := &serverConfig{}
+	fs.DurationVar(&cfg.readTimeout, "read-timeout", envDuration("GALAXY_READ_TIMEOUT", defaultReadTimeout), "maximum duration for reading a request, 0 for none (GALAXY_READ_TIMEOUT)")
+	fs.DurationVar(&cfg.writeTimeout, "write-timeout", envDuration("GALAXY_WRITE_TIMEOUT", defaultWriteTimeout), "maximum duration for writing a response, except event streams, which last until the evaluation timeout, 0 for none (GALAXY_WRITE_TIMEOUT)")
+	fs.DurationVar(&cfg.idleTimeout, "idle-timeout", envDuration("GALAXY_IDLE_TIMEOUT", defaultIdleTimeout), "how long idle keep-alive connections stay open, 0 for the read timeout (GALAXY_IDLE_TIMEOUT)")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", envDuration("GALAXY_SHUTDOWN_TIMEOUT", defaultShutdownTimeout), "how long shutdown waits for requests in flight (GALAXY_SHUTDOWN_TIMEOUT)")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", envOr("GALAXY_TLS_CERT", ""), "certificate file to serve HTTPS with, together with -tls-key (GALAXY_TLS_CERT)")
+	fs.StringVar(&cfg.tlsKey, "tls-key", envOr("GALAXY_TLS_KEY", ""), "private key file of -tls-cert (GALAXY_TLS_KEY)")
+	fs.StringVar(&cfg.stateFile, "state-file", envOr("GALAXY_STATE_FILE", ""), "file the shared states are loaded from on start and saved to on shutdown (GALAXY_STATE_FILE)")
+	return cfg
+}
+
+// limitFlags adds the flags that configure the request limits of serve.
+func limitFlags(fs *flag.FlagSet) *Limits {
+	limits := &Limits{}
+	fs.Int64Var(&limits.MaxBodyBytes, "max-body-bytes", envInt("GALAXY_MAX_BODY_BYTES", defaultMaxBodyBytes), "maximum size of eval and interact request bodies, 0 for no limit (GALAXY_MAX_BODY_BYTES)")
+	fs.Float64Var(&limits.Rate, "rate", envFloat("GALAXY_RATE", defaultRate), "requests per second each client may make to eval and interact, 0 for no limit (GALAXY_RATE)")
+	burst := envInt("GALAXY_BURST", defaultBurst)
+	fs.IntVar(&limits.Burst, "burst", int(burst), "requests a client may make at once above -rate (GALAXY_BURST)")
+	maxEvals := envInt("GALAXY_MAX_EVALS", int64(runtime.GOMAXPROCS(0)))
+	fs.IntVar(&limits.MaxEvals, "max-evals", int(maxEvals), "evaluations that may run at once, 0 for no limit (GALAXY_MAX_EVALS)")
+	queue := envInt("GALAXY_EVAL_QUEUE", defaultEvalQueue)
+	fs.IntVar(&limits.MaxQueue, "eval-queue", int(queue), "evaluations that may wait for one of -max-evals (GALAXY_EVAL_QUEUE)")
+	fs.DurationVar(&limits.QueueWait, "queue-wait", envDuration("GALAXY_QUEUE_WAIT", defaultQueueWait), "how long a queued evaluation waits before giving up (GALAXY_QUEUE_WAIT)")
+	fs.Int64Var(&limits.StepRate, "step-rate", envInt("GALAXY_STEP_RATE", defaultStepRate), "reduction steps per second each client may use, 0 for no limit (GALAXY_STEP_RATE)")
+	fs.Int64Var(&limits.StepBurst, "step-burst", envInt("GALAXY_STEP_BURST", defaultStepBurst), "reduction steps a client may save up, which also bounds each request (GALAXY_STEP_BURST)")
+	return limits
+}
+
+// runEval evaluates the expression given as arguments, or read from stdin
+// when there are none, and prints it like the /eval endpoint. A result that
+// was cut short by the budget is reported on stderr.
+func runEval(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var cfg config
+	fs := newFlagSet("eval", &cfg)
+	cfg.internFlag(fs)
+	mode := fs.String("normalize", "whnf", "how far to normalize the result: whnf, nf or depth")
+	depthFlag := fs.Int("depth", 0, "number of levels to normalize with -normalize depth")
+	budget := fs.Int64("budget", 0, "maximum number of reduction steps, 0 for the default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+	depth, err := parseNormalization(*mode, *depthFlag)
+	if err != nil {
+		return err
+	}
+
+	source := strings.Join(fs.Args(), " ")
+	if fs.NArg() == 0 {
+		byts, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		source = string(byts)
+	}
+	expr, err := parseLine(source)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := cfg.context()
+	defer cancel()
+	result, truncated, err := normalize(withBudget(ctx, *budget), expr, defaultSymbols(), depth)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, formatValue(result))
+	if truncated {
+		fmt.Fprintln(stderr, "galaxy: normalization stopped early: reduction budget exhausted")
+	}
+	return nil
+}
+
+// interactFlags adds the -state, -x and -y flags used by interact and render.
+func interactFlags(fs *flag.FlagSet) (state *string, x, y *int64) {
+	state = fs.String("state", "nil", "state expression to pass to galaxy")
+	x = fs.Int64("x", 0, "x coordinate of the clicked point")
+	y = fs.Int64("y", 0, "y coordinate of the clicked point")
+	return state, x, y
+}
+
+func interactFromFlags(cfg *config, state string, x, y int64) (InteractResponse, error) {
+	stateExpr, err := parseLine(state)
+	if err != nil {
+		return InteractResponse{}, fmt.Errorf("invalid state: %w", err)
+	}
+	ctx, cancel := cfg.context()
+	defer cancel()
+	return interact(ctx, defaultSymbols(), stateExpr, x, y)
+}
+
+func runInteract(args []string, stdout io.Writer) error {
+	var cfg config
+	fs := newFlagSet("interact", &cfg)
+	cfg.internFlag(fs)
+	cfg.sendFlags(fs)
+	state, x, y := interactFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	resp, err := interactFromFlags(&cfg, *state, *x, *y)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(stdout).Encode(resp)
+}
+
+func runRender(args []string, stdout io.Writer) error {
+	var cfg config
+	fs := newFlagSet("render", &cfg)
+	cfg.internFlag(fs)
+	cfg.sendFlags(fs)
+	state, x, y := interactFlags(fs)
+	out := fs.String("o", "", "write a PNG to this path instead of drawing text")
+	scale := fs.Int("scale", 8, "pixels per galaxy point in PNG output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	resp, err := interactFromFlags(&cfg, *state, *x, *y)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Fprint(stdout, renderText(resp.Images))
+		return nil
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, renderPNG(resp.Images, *scale)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// imageBounds returns the smallest rectangle containing every point.
+func imageBounds(images [][]PointPair) image.Rectangle {
+	var bounds image.Rectangle
+	first := true
+	for _, img := range images {
+		for _, p := range img {
+			r := image.Rect(int(p.X), int(p.Y), int(p.X)+1, int(p.Y)+1)
+			if first {
+				bounds, first = r, false
+			} else {
+				bounds = bounds.Union(r)
+			}
+		}
+	}
+	return bounds
+}
+
+// layerChars and layerColors mark the image layers, which are drawn last to
+// first so the first layer ends up on top.
+const layerChars = "#*+o%x"
+
+var layerColors = []color.RGBA{
+	{0xFF, 0x00, 0x00, 0xFF}, {0x00, 0xFF, 0x00, 0xFF}, {0x00, 0x00, 0xFF, 0xFF},
+	{0xFF, 0xFF, 0x00, 0xFF}, {0xFF, 0x00, 0xFF, 0xFF}, {0x00, 0xFF, 0xFF, 0xFF},
+}
+
+func renderText(images [][]PointPair) string {
+	bounds := imageBounds(images)
+	if bounds.Empty() {
+		return ""
+	}
+	grid := make([][]byte, bounds.Dy())
+	for i := range grid {
+		grid[i] = []byte(strings.Repeat(".", bounds.Dx()))
+	}
+	for layer := len(images) - 1; layer >= 0; layer-- {
+		for _, p := range images[layer] {
+			grid[int(p.Y)-bounds.Min.Y][int(p.X)-bounds.Min.X] = layerChars[layer%len(layerChars)]
+		}
+	}
+	var sb strings.Builder
+	for _, row := range grid {
+		sb.Write(row)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func renderPNG(images [][]PointPair, scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	bounds := imageBounds(images)
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for layer := len(images) - 1; layer >= 0; layer-- {
+		c := layerColors[layer%len(layerColors)]
+		for _, p := range images[layer] {
+			px := (int(p.X) - bounds.Min.X) * scale
+			py := (int(p.Y) - bounds.Min.Y) * scale
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetRGBA(px+dx, py+dy, c)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// builtins are the symbols the evaluator implements itself.
+var builtins = map[Symbol]bool{
+	"neg": true, "i": true, "nil": true, "isnil": true, "car": true, "cdr": true,
+	"t": true, "f": true, "add": true, "mul": true, "div": true, "lt": true,
+	"eq": true, "cons": true, "s": true, "c": true, "b": true,
+}
+
+// undefinedSymbols returns, sorted, the symbols referenced by program that
+// are neither defined in it nor built in.
+func undefinedSymbols(program map[Symbol]Expr) []Symbol {
+	missing := map[Symbol]bool{}
+	for _, expr := range program {
+		for _, s := range references(expr) {
+			if _, ok := program[s]; !ok && !builtins[s] {
+				missing[s] = true
+			}
+		}
+	}
+	var result []Symbol
+	for s := range missing {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+var errCheckFailed = errors.New("check failed")
+
+func runCheck(args []string, stdout io.Writer) error {
+	var cfg config
+	fs := newFlagSet("check", &cfg)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	symbols := defaultSymbols()
+	fmt.Fprintf(stdout, "%s: %d symbols\n", cfg.programPath, len(symbols))
+	missing := undefinedSymbols(symbols)
+	for _, s := range missing {
+		fmt.Fprintf(stdout, "undefined symbol: %s\n", s)
+	}
+	if _, ok := symbols["galaxy"]; !ok {
+		fmt.Fprintln(stdout, "warning: no galaxy entry point")
+	}
+	if len(missing) > 0 {
+		return errCheckFailed
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunEval(t *testing.T) {
+	var out, errOut bytes.Buffer
+	assert.NoError(t, runEval([]string{"-program", "galaxy.txt", "ap", "ap", "add", "2", "3"}, nil, &out, &errOut))
+	assert.Equal(t, "5\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, runEval([]string{"-program", "galaxy.txt"}, strings.NewReader("ap ap cons 1 nil\n"), &out, &errOut))
+	assert.Equal(t, "[1]\n", out.String())
+	assert.Empty(t, errOut.String())
+
+	out.Reset()
+	assert.NoError(t, runEval([]string{"-program", "galaxy.txt", "-normalize", "nf", "-budget", "100", "ap", "add", "ap", "ap", "ap", "s", "i", "i", "ap", "ap", "s", "i", "i"}, nil, &out, &errOut))
+	assert.Equal(t, "galaxy: normalization stopped early: reduction budget exhausted\n", errOut.String())
+
+	assert.Error(t, runEval([]string{"-program", "missing.txt", "1"}, nil, &out, &errOut))
+	assert.Error(t, runEval([]string{"-program", "galaxy.txt", "-log-level", "loud", "1"}, nil, &out, &errOut))
+}
+
+func TestRunInteractAndRender(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, runInteract([]string{"-program", "galaxy.txt", "-state", "nil"}, &out))
+	assert.Contains(t, out.String(), `"newstate":"ap ap cons 0 ap ap cons ap ap cons 0 nil ap ap cons 0 ap ap cons nil nil"`)
+
+	out.Reset()
+	assert.NoError(t, runRender([]string{"-program", "galaxy.txt"}, &out))
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	// The first frame spans x in [-8, 3] and y in [-3, 3].
+	assert.Len(t, lines, 7)
+	assert.Equal(t, 12, len(lines[0]))
+	assert.Equal(t, ".......###..", lines[6])
+}
+
+func TestRunCheck(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, runCheck([]string{"-program", "galaxy.txt"}, &out))
+	assert.Equal(t, "galaxy.txt: 393 symbols\n", out.String())
+
+	// Only the subcommands that interact send, or record sends.
+	record := filepath.Join(t.TempDir(), "sends.log")
+	assert.ErrorContains(t, runCheck([]string{"-program", "galaxy.txt", "-send-record", record}, &out), "flag provided but not defined: -send-record")
+	assert.NoFileExists(t, record)
+
+	assert.Equal(t, []Symbol{"missing"}, undefinedSymbols(map[Symbol]Expr{
+		"a":   &Ap{Left: Symbol("inc"), Right: Symbol("missing")},
+		"inc": &Ap{Left: Symbol("add"), Right: Number(1)},
+	}))
+}
+
+func TestRunCLIUnknownCommand(t *testing.T) {
+	assert.ErrorContains(t, runCLI([]string{"frobnicate"}), `unknown command "frobnicate"`)
+}
+
+func TestRunCLIHelp(t *testing.T) {
+	for _, cmd := range []string{"tournament", "check", "eval", "repl"} {
+		assert.NoError(t, runCLI([]string{cmd, "-h"}), cmd)
+	}
+}
+
+func TestEvalContextTimeout(t *testing.T) {
+	// loop = ap ap s i i applied to itself never terminates.
+	omega := &Ap{Left: &Ap{Left: Symbol("s"), Right: Symbol("i")}, Right: Symbol("i")}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := evalContext(ctx, &Ap{Left: omega, Right: omega}, map[Symbol]Expr{})
+	assert.ErrorIs(t, err, errEvalTimeout)
+}
@@ -0,0 +1,473 @@
+// Package client drives a running galaxy interpreter over its HTTP API,
+// which the server describes at /openapi.json. The package is written by
+// hand, not generated from that document: its types are kept in step with
+// the document's schemas and the server's own types by hand too.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client sends requests to the server at BaseURL, such as
+// "http://localhost:8080".
+type Client struct {
+	BaseURL string
+	// HTTPClient sends the requests; http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// New returns a client of the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Error is a response with an error status.
+type Error struct {
+	StatusCode int
+	// Message is the error the server reported, or the status text when
+	// it didn't report one.
+	Message string
+	// RequestID is the X-Request-ID of the response, to find the request
+	// in the server's log.
+	RequestID string
+	// RetryAfter is how long the server asked to wait before trying again,
+	// for rate limited and busy responses.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Value is a value in the tagged JSON encoding. Type is "int", "nil",
+// "list", "pair" or "unevaluated".
+type Value struct {
+	Type  string      `json:"type"`
+	Value json.Number `json:"value,omitempty"`
+	Items []Value     `json:"items,omitempty"`
+	Head  *Value      `json:"head,omitempty"`
+	Tail  *Value      `json:"tail,omitempty"`
+	Expr  string      `json:"expr,omitempty"`
+}
+
+// Int returns the value of the number n.
+func Int(n int64) Value {
+	return Value{Type: "int", Value: json.Number(strconv.FormatInt(n, 10))}
+}
+
+// Nil returns the empty list.
+func Nil() Value {
+	return Value{Type: "nil"}
+}
+
+// List returns the list of items, or Nil when there are none.
+func List(items ...Value) Value {
+	if len(items) == 0 {
+		return Nil()
+	}
+	return Value{Type: "list", Items: items}
+}
+
+// Pair returns the pair of head and tail.
+func Pair(head, tail Value) Value {
+	return Value{Type: "pair", Head: &head, Tail: &tail}
+}
+
+// Int64 returns the number of an int.
+func (v Value) Int64() (int64, error) {
+	if v.Type != "int" {
+		return 0, fmt.Errorf("value is a %s, not an int", v.Type)
+	}
+	return strconv.ParseInt(v.Value.String(), 10, 64)
+}
+
+type Point struct {
+	X int64 `json:"x"`
+	Y int64 `json:"y"`
+}
+
+// EvalRequest evaluates Expression against Program, normalized as far as
+// Normalize says: "whnf" (the default), "nf" or "depth", the top Depth
+// levels. Budget, when positive, limits the reduction steps.
+type EvalRequest struct {
+	Expression string `json:"expression"`
+	Program    string `json:"program,omitempty"`
+	Normalize  string `json:"normalize,omitempty"`
+	Depth      int    `json:"depth,omitempty"`
+	Budget     int64  `json:"budget,omitempty"`
+}
+
+type EvalResponse struct {
+	Result    *Value `json:"result,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// InteractRequest clicks Point on the screen of a state, given as
+// StateValue or, when that is nil, printed in State.
+type InteractRequest struct {
+	State      string `json:"state"`
+	StateValue *Value `json:"stateValue,omitempty"`
+	Program    string `json:"program,omitempty"`
+	Send       bool   `json:"send,omitempty"`
+	Point      Point  `json:"point"`
+}
+
+type InteractResponse struct {
+	Flag          int64     `json:"flag"`
+	NewState      string    `json:"newstate"`
+	NewStateValue *Value    `json:"newstateValue,omitempty"`
+	Images        [][]Point `json:"images"`
+	Data          string    `json:"data,omitempty"`
+	Hash          string    `json:"hash,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// InteractEvent is the progress of a streamed interaction. Type is one of
+// "started", "flag", "send", "received", "image" and "done". Flag is set on
+// "flag" events and Layer on "image" events, even when zero.
+type InteractEvent struct {
+	Type   string            `json:"type"`
+	Round  int               `json:"round"`
+	Flag   *int64            `json:"flag,omitempty"`
+	Data   string            `json:"data,omitempty"`
+	Layer  *int              `json:"layer,omitempty"`
+	Image  []Point           `json:"image,omitempty"`
+	Result *InteractResponse `json:"result,omitempty"`
+}
+
+type StoredState struct {
+	Hash      string    `json:"hash"`
+	Program   string    `json:"program"`
+	State     string    `json:"state"`
+	Images    [][]Point `json:"images"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ProgramInfo struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path,omitempty"`
+	Symbols     int       `json:"symbols"`
+	EntryPoints []string  `json:"entryPoints"`
+	LoadedAt    time.Time `json:"loadedAt"`
+}
+
+type SymbolResponse struct {
+	Program      string   `json:"program"`
+	Name         string   `json:"name"`
+	Definition   string   `json:"definition"`
+	References   []string `json:"references"`
+	ReferencedBy []string `json:"referencedBy"`
+	Error        string   `json:"error,omitempty"`
+}
+
+type GraphNode struct {
+	Name       string   `json:"name"`
+	References []string `json:"references"`
+}
+
+type GraphResponse struct {
+	Program    string      `json:"program"`
+	Nodes      []GraphNode `json:"nodes"`
+	Components [][]string  `json:"components"`
+	Error      string      `json:"error,omitempty"`
+}
+
+type CacheResponse struct {
+	Entries     int     `json:"entries"`
+	MaxEntries  int     `json:"maxEntries"`
+	TTL         string  `json:"ttl"`
+	Hits        uint64  `json:"hits"`
+	Misses      uint64  `json:"misses"`
+	Evictions   uint64  `json:"evictions"`
+	Expirations uint64  `json:"expirations"`
+	HitRate     float64 `json:"hitRate"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type SymbolMemory struct {
+	Symbol string `json:"symbol"`
+	Nodes  int    `json:"nodes"`
+}
+
+type ProgramMemory struct {
+	Name       string         `json:"name"`
+	Generation int64          `json:"generation"`
+	Nodes      int            `json:"nodes"`
+	Symbols    []SymbolMemory `json:"symbols"`
+}
+
+type MemoryResponse struct {
+	HeapAlloc uint64          `json:"heapAlloc"`
+	HeapLimit uint64          `json:"heapLimit,omitempty"`
+	EvalSteps int64           `json:"evalSteps"`
+	CacheHits int64           `json:"cacheHits"`
+	Programs  []ProgramMemory `json:"programs"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ProgramVersion struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Symbols int    `json:"symbols"`
+}
+
+type VersionResponse struct {
+	GoVersion    string          `json:"goVersion"`
+	Module       string          `json:"module"`
+	Version      string          `json:"version"`
+	Revision     string          `json:"revision,omitempty"`
+	RevisionTime string          `json:"revisionTime,omitempty"`
+	Modified     bool            `json:"modified,omitempty"`
+	Program      *ProgramVersion `json:"program,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// Eval evaluates an expression.
+func (c *Client) Eval(ctx context.Context, req EvalRequest) (*EvalResponse, error) {
+	var resp EvalResponse
+	return &resp, c.do(ctx, http.MethodPost, "/eval", req, &resp)
+}
+
+// Interact clicks on a galaxy screen.
+func (c *Client) Interact(ctx context.Context, req InteractRequest) (*InteractResponse, error) {
+	var resp InteractResponse
+	return &resp, c.do(ctx, http.MethodPost, "/interact", req, &resp)
+}
+
+// InteractStream clicks on a galaxy screen like Interact, calling onEvent,
+// when it is not nil, with each event of the interaction's progress as it
+// arrives.
+func (c *Client) InteractStream(ctx context.Context, req InteractRequest, onEvent func(InteractEvent)) (*InteractResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/interact/stream", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var name string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<26)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v, ok := strings.CutPrefix(line, "event: "); ok {
+			name = v
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if name == "failed" {
+			var failed InteractResponse
+			if err := json.Unmarshal([]byte(data), &failed); err != nil {
+				return nil, err
+			}
+			return nil, errors.New(failed.Error)
+		}
+		var ev InteractEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return nil, err
+		}
+		if onEvent != nil {
+			onEvent(ev)
+		}
+		if ev.Type == "done" {
+			if ev.Result == nil {
+				return nil, errors.New("done event without a result")
+			}
+			return ev.Result, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// State fetches a stored state by hash.
+func (c *Client) State(ctx context.Context, hash string) (*StoredState, error) {
+	var resp StoredState
+	return &resp, c.do(ctx, http.MethodGet, "/states/"+url.PathEscape(hash), nil, &resp)
+}
+
+// Programs lists the programs by name.
+func (c *Client) Programs(ctx context.Context) ([]ProgramInfo, error) {
+	var resp struct {
+		Programs []ProgramInfo `json:"programs"`
+	}
+	return resp.Programs, c.do(ctx, http.MethodGet, "/programs", nil, &resp)
+}
+
+type programResponse struct {
+	Program *ProgramInfo `json:"program"`
+}
+
+// Program describes a program.
+func (c *Client) Program(ctx context.Context, name string) (*ProgramInfo, error) {
+	var resp programResponse
+	return resp.Program, c.do(ctx, http.MethodGet, "/programs/"+url.PathEscape(name), nil, &resp)
+}
+
+// PutProgram uploads a program in the galaxy.txt format, replacing any
+// uploaded program with the same name. Programs the server loaded from
+// files can't be replaced.
+func (c *Client) PutProgram(ctx context.Context, name, source string) (*ProgramInfo, error) {
+	resp, err := c.send(ctx, http.MethodPut, "/programs/"+url.PathEscape(name), "text/plain", strings.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var created programResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, err
+	}
+	return created.Program, nil
+}
+
+// DeleteProgram deletes an uploaded program. Programs the server loaded
+// from files can't be deleted.
+func (c *Client) DeleteProgram(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/programs/"+url.PathEscape(name), nil, nil)
+}
+
+// Symbol describes a definition of a program.
+func (c *Client) Symbol(ctx context.Context, program, symbol string) (*SymbolResponse, error) {
+	var resp SymbolResponse
+	return &resp, c.do(ctx, http.MethodGet, "/programs/"+url.PathEscape(program)+"/symbols/"+url.PathEscape(symbol), nil, &resp)
+}
+
+// Graph returns the dependency graph of a program.
+func (c *Client) Graph(ctx context.Context, program string) (*GraphResponse, error) {
+	var resp GraphResponse
+	return &resp, c.do(ctx, http.MethodGet, "/programs/"+url.PathEscape(program)+"/graph", nil, &resp)
+}
+
+// Cache reports the interaction result cache.
+func (c *Client) Cache(ctx context.Context) (*CacheResponse, error) {
+	var resp CacheResponse
+	return &resp, c.do(ctx, http.MethodGet, "/cache", nil, &resp)
+}
+
+// FlushCache empties the interaction result cache.
+func (c *Client) FlushCache(ctx context.Context) (*CacheResponse, error) {
+	var resp CacheResponse
+	return &resp, c.do(ctx, http.MethodDelete, "/cache", nil, &resp)
+}
+
+// Memory reports the evaluation caches.
+func (c *Client) Memory(ctx context.Context) (*MemoryResponse, error) {
+	var resp MemoryResponse
+	return &resp, c.do(ctx, http.MethodGet, "/debug/memory", nil, &resp)
+}
+
+// FlushMemory empties the evaluation caches.
+func (c *Client) FlushMemory(ctx context.Context) (*MemoryResponse, error) {
+	var resp MemoryResponse
+	return &resp, c.do(ctx, http.MethodDelete, "/debug/memory", nil, &resp)
+}
+
+// Health reports whether the server is alive.
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	var resp HealthResponse
+	return &resp, c.do(ctx, http.MethodGet, "/healthz", nil, &resp)
+}
+
+// Ready reports whether the server's default program works; it fails with
+// an *Error of status 503 when it doesn't.
+func (c *Client) Ready(ctx context.Context) (*HealthResponse, error) {
+	var resp HealthResponse
+	return &resp, c.do(ctx, http.MethodGet, "/readyz", nil, &resp)
+}
+
+// Version reports the build of the server and its default program.
+func (c *Client) Version(ctx context.Context) (*VersionResponse, error) {
+	var resp VersionResponse
+	return &resp, c.do(ctx, http.MethodGet, "/version", nil, &resp)
+}
+
+// do sends body, when it is not nil, as JSON and decodes the response into
+// out, when it is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		byts, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(byts)
+	}
+	resp, err := c.send(ctx, method, path, "application/json", r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends a request and returns the response when its status is
+// successful, and an *Error otherwise.
+func (c *Client) send(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, responseError(resp)
+}
+
+func responseError(resp *http.Response) *Error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body) == nil && body.Error != "" {
+		e.Message = body.Error
+	}
+	return e
+}
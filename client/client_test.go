@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueEncoding(t *testing.T) {
+	v := List(Int(1), Pair(Int(-2), Nil()))
+	byts, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "list", "items": [
+		{"type": "int", "value": 1},
+		{"type": "pair", "head": {"type": "int", "value": -2}, "tail": {"type": "nil"}}
+	]}`, string(byts))
+	assert.Equal(t, Nil(), List())
+
+	var big Value
+	require.NoError(t, json.Unmarshal([]byte(`{"type": "int", "value": "9007199254740993"}`), &big))
+	n, err := big.Int64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(9007199254740993), n)
+	_, err = Nil().Int64()
+	assert.EqualError(t, err, "value is a nil, not an int")
+}
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "abc")
+		switch r.URL.Path {
+		case "/eval":
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": "Rate limit exceeded"}`)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "upstream down")
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+
+	_, err := c.Eval(context.Background(), EvalRequest{Expression: "1"})
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, &Error{StatusCode: http.StatusTooManyRequests, Message: "Rate limit exceeded", RequestID: "abc", RetryAfter: 3 * time.Second}, apiErr)
+	assert.EqualError(t, err, "429 Too Many Requests: Rate limit exceeded")
+
+	_, err = c.Health(context.Background())
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "Bad Gateway", apiErr.Message)
+}
+
+func TestInteractStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req InteractRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: started\ndata: {\"type\":\"started\",\"round\":0}\n\n")
+		switch req.State {
+		case "broken":
+			fmt.Fprint(w, "event: failed\ndata: {\"flag\":0,\"newstate\":\"\",\"images\":null,\"error\":\"evaluation timed out\"}\n\n")
+			return
+		case "empty":
+			fmt.Fprint(w, "event: done\ndata: {\"type\":\"done\",\"round\":0}\n\n")
+			return
+		}
+		fmt.Fprint(w, "event: flag\ndata: {\"type\":\"flag\",\"round\":0,\"flag\":0}\n\n")
+		fmt.Fprint(w, "event: done\ndata: {\"type\":\"done\",\"round\":0,\"result\":{\"flag\":0,\"newstate\":\"nil\",\"images\":[]}}\n\n")
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+
+	var events []string
+	var flag *int64
+	resp, err := c.InteractStream(context.Background(), InteractRequest{State: "nil"}, func(ev InteractEvent) {
+		events = append(events, ev.Type)
+		if ev.Type == "flag" {
+			flag = ev.Flag
+		}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &InteractResponse{NewState: "nil", Images: [][]Point{}}, resp)
+	assert.Equal(t, []string{"started", "flag", "done"}, events)
+	require.NotNil(t, flag)
+	assert.Equal(t, int64(0), *flag)
+
+	_, err = c.InteractStream(context.Background(), InteractRequest{State: "broken"}, nil)
+	assert.EqualError(t, err, "evaluation timed out")
+	_, err = c.InteractStream(context.Background(), InteractRequest{State: "empty"}, nil)
+	assert.EqualError(t, err, "done event without a result")
+}
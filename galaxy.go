@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 type Expr interface {
@@ -34,21 +37,58 @@ func parseProgram(path string) (map[Symbol]Expr, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parseProgramText(string(byts))
+}
 
-	lines := strings.Split(string(byts), "\n")
+// parseProgramText parses program source in the galaxy.txt format: one
+// "name = expr" definition per line.
+func parseProgramText(text string) (map[Symbol]Expr, error) {
+	lines := strings.Split(text, "\n")
 
 	symbols := map[Symbol]Expr{}
-	for _, line := range lines {
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 		pieces := strings.Split(line, " = ")
 		if len(pieces) != 2 {
 			return nil, fmt.Errorf("invalid line: %s", line)
 		}
-		symbols[Symbol(pieces[0])], _ = parseExpr(strings.Split(pieces[1], " "))
+		expr, remaining := parseExpr(strings.Fields(pieces[1]))
+		if expr == nil || len(remaining) > 0 {
+			return nil, fmt.Errorf("line %d: invalid expression for %s", i+1, pieces[0])
+		}
+		symbols[Symbol(pieces[0])] = expr
 	}
 
 	return symbols, nil
 }
 
+// copyDefinitions returns a copy of a symbol table with new application
+// nodes that hold no cached values, so that evaluating against the copy
+// neither sees nor changes the values cached in the original.
+func copyDefinitions(symbols map[Symbol]Expr) map[Symbol]Expr {
+	copies := map[*Ap]*Ap{}
+	var copyExpr func(Expr) Expr
+	copyExpr = func(expr Expr) Expr {
+		a, ok := expr.(*Ap)
+		if !ok {
+			return expr
+		}
+		if c, ok := copies[a]; ok {
+			return c
+		}
+		c := &Ap{Left: copyExpr(a.Left), Right: copyExpr(a.Right)}
+		copies[a] = c
+		return c
+	}
+	result := make(map[Symbol]Expr, len(symbols))
+	for name, expr := range symbols {
+		result[name] = copyExpr(expr)
+	}
+	return result
+}
+
 func parseExpr(terms []string) (Expr, []string) {
 	if len(terms) == 0 {
 		return nil, terms
@@ -61,6 +101,9 @@ func parseExpr(terms []string) (Expr, []string) {
 	case "ap":
 		left, rest := parseExpr(rest)
 		right, rest := parseExpr(rest)
+		if left == nil || right == nil {
+			return nil, rest
+		}
 		return &Ap{Left: left, Right: right}, rest
 	default:
 		if num, err := strconv.ParseInt(token, 10, 64); err == nil {
@@ -83,57 +126,188 @@ func printExpr(expr Expr) string {
 	}
 }
 
+// evaluator reduces expressions against a symbol table.
+type evaluator struct {
+	symbols map[Symbol]Expr
+	// trace, when set, is called for every reduction step eval takes.
+	trace func(depth int, from, to Expr)
+	// ctx, when set, is polled so that long evaluations can be abandoned.
+	ctx context.Context
+	// intern, when set, hash-conses the applications reductions build.
+	intern *interner
+	// budget, when set, bounds the steps. They are spent from it when the
+	// meter is flushed; allowance is what was left of it then.
+	budget    *stepBudget
+	allowance int64
+	// meter, when set, counts the steps.
+	meter *atomic.Int64
+	depth int
+	// steps and hits count the reduction steps and the hits of the Ap.v
+	// cache; metered and counted are how many of them were flushed to the
+	// meter and serverMetrics.
+	steps   int64
+	metered int64
+	hits    int64
+	counted int64
+
+	// truncated is set when normalize ran out of budget.
+	truncated bool
+}
+
+// errEvalTimeout is returned when an evaluation outlives its deadline.
+var errEvalTimeout = errors.New("evaluation timed out")
+
+// errBudgetExhausted is returned when an evaluation takes more steps than
+// its budget allows.
+var errBudgetExhausted = errors.New("reduction budget exhausted")
+
+// abortError returns the error work under ctx, which is done, fails with:
+// errEvalTimeout when its deadline passed, or the cause it was cancelled
+// with.
+func abortError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errEvalTimeout
+	}
+	return context.Cause(ctx)
+}
+
+// ctxCheckInterval is how many reduction steps pass between context checks.
+const ctxCheckInterval = 4096
+
+// evalAborted is the panic value used to unwind an evaluation whose context
+// is done.
+type evalAborted struct{ err error }
+
 func eval(expr Expr, symbols map[Symbol]Expr) Expr {
+	return (&evaluator{symbols: symbols}).eval(expr)
+}
+
+// evalContext evaluates expr, returning an error instead of panicking when
+// the expression is ill-typed or ctx is done first.
+func evalContext(ctx context.Context, expr Expr, symbols map[Symbol]Expr) (Expr, error) {
+	return newEvaluator(ctx, symbols).view(expr).force()
+}
+
+func (ev *evaluator) evalSafe(expr Expr) (result Expr, err error) {
+	defer recoverEval(&err)
+	return ev.eval(expr), nil
+}
+
+// recoverEval turns the panic of an aborted or ill-typed evaluation into an
+// error in *err. It must be deferred.
+func recoverEval(err *error) {
+	if r := recover(); r != nil {
+		if aborted, ok := r.(evalAborted); ok {
+			*err = aborted.err
+			return
+		}
+		*err = fmt.Errorf("evaluation failed: %v", r)
+	}
+}
+
+func (ev *evaluator) eval(expr Expr) Expr {
 	if a, ok := expr.(*Ap); ok && a.v != nil {
+		ev.hits++
+		if ev.depth == 0 {
+			ev.flushMeter()
+		}
 		return a.v
 	}
+	ev.depth++
+	if ev.depth == 1 && ev.budget != nil {
+		// Other evaluators may have spent from the budget meanwhile.
+		ev.allowance = ev.budget.remaining()
+	}
 	initialExpr := expr
 	for {
-		result := tryEval(expr, symbols)
+		result := ev.tryEval(expr)
 		if result == expr {
 			if a, ok := initialExpr.(*Ap); ok && a.v == nil {
 				a.v = expr
 			}
+			ev.depth--
+			if ev.depth == 0 {
+				ev.flushMeter()
+			}
 			return result
 		}
+		if ev.trace != nil {
+			ev.trace(ev.depth, expr, result)
+		}
+		ev.steps++
+		if ev.budget != nil && ev.steps-ev.metered > ev.allowance {
+			ev.flushMeter()
+			panic(evalAborted{errBudgetExhausted})
+		}
+		if ev.ctx != nil && ev.steps%ctxCheckInterval == 0 {
+			ev.flushMeter()
+			if ev.ctx.Err() != nil {
+				panic(evalAborted{abortError(ev.ctx)})
+			}
+		}
 		expr = result
 	}
 }
 
+// flushMeter spends the steps taken since the last flush from the budget
+// and adds them to the meter and, with the cache hits, to serverMetrics.
+func (ev *evaluator) flushMeter() {
+	steps := ev.steps - ev.metered
+	if ev.budget != nil {
+		ev.budget.spend(steps)
+		ev.allowance = ev.budget.remaining()
+	}
+	if ev.meter != nil {
+		ev.meter.Add(steps)
+	}
+	serverMetrics.steps.Add(steps)
+	serverMetrics.cacheHits.Add(ev.hits - ev.counted)
+	ev.metered, ev.counted = ev.steps, ev.hits
+}
+
+// ap builds an application, hash-consed when the evaluator interns.
+func (ev *evaluator) ap(left, right Expr) *Ap {
+	if ev.intern != nil {
+		return ev.intern.ap(left, right)
+	}
+	return &Ap{Left: left, Right: right}
+}
+
 const t = Symbol("t")
 const f = Symbol("f")
 const cons = Symbol("cons")
 
-func tryEval(expr Expr, symbols map[Symbol]Expr) Expr {
+func (ev *evaluator) tryEval(expr Expr) Expr {
 	if a, ok := expr.(*Ap); ok && a.v != nil {
+		ev.hits++
 		return a.v
 	}
 	switch e := expr.(type) {
 	case Symbol:
-		if val, ok := symbols[e]; ok {
+		if val, ok := ev.symbols[e]; ok {
 			return val
 		}
 	case *Ap:
-		fun := eval(e.Left, symbols)
+		fun := ev.eval(e.Left)
 		x := e.Right
 		switch fun := fun.(type) {
 		case Symbol:
 			switch fun {
 			case "neg":
-				return -eval(x, symbols).(Number)
+				return -ev.eval(x).(Number)
 			case "i":
 				return x
 			case "nil":
 				return t
 			case "isnil":
-				return &Ap{x, &Ap{t, &Ap{t, f, nil}, nil}, nil}
+				return ev.ap(x, ev.ap(t, ev.ap(t, f)))
 			case "car":
-				return &Ap{x, t, nil}
+				return ev.ap(x, t)
 			case "cdr":
-				return &Ap{x, f, nil}
+				return ev.ap(x, f)
 			}
 		case *Ap:
-			fun2 := eval(fun.Left, symbols)
+			fun2 := ev.eval(fun.Left)
 			y := fun.Right
 			switch fun2 := fun2.(type) {
 			case Symbol:
@@ -143,42 +317,42 @@ func tryEval(expr Expr, symbols map[Symbol]Expr) Expr {
 				case "f":
 					return x
 				case "add":
-					return eval(x, symbols).(Number) + eval(y, symbols).(Number)
+					return ev.eval(x).(Number) + ev.eval(y).(Number)
 				case "mul":
-					return eval(x, symbols).(Number) * eval(y, symbols).(Number)
+					return ev.eval(x).(Number) * ev.eval(y).(Number)
 				case "div":
-					return eval(y, symbols).(Number) / eval(x, symbols).(Number)
+					return ev.eval(y).(Number) / ev.eval(x).(Number)
 				case "lt":
-					if eval(y, symbols).(Number) < eval(x, symbols).(Number) {
+					if ev.eval(y).(Number) < ev.eval(x).(Number) {
 						return t
 					}
 					return f
 				case "eq":
-					vx := eval(x, symbols)
-					vy := eval(y, symbols)
+					vx := ev.eval(x)
+					vy := ev.eval(y)
 					if vx.(Number) == vy.(Number) {
 						return t
 					}
 					return f
 				case "cons":
-					res := &Ap{Left: &Ap{Left: cons, Right: eval(y, symbols)}, Right: eval(x, symbols)}
+					res := ev.ap(ev.ap(cons, ev.eval(y)), ev.eval(x))
 					res.v = res
 					return res
 				}
 			case *Ap:
-				fun3 := eval(fun2.Left, symbols)
+				fun3 := ev.eval(fun2.Left)
 				z := fun2.Right
 				switch fun3 := fun3.(type) {
 				case Symbol:
 					switch fun3 {
 					case "s":
-						return &Ap{Left: &Ap{Left: z, Right: x}, Right: &Ap{Left: y, Right: x}}
+						return ev.ap(ev.ap(z, x), ev.ap(y, x))
 					case "c":
-						return &Ap{Left: &Ap{Left: z, Right: x}, Right: y}
+						return ev.ap(ev.ap(z, x), y)
 					case "b":
-						return &Ap{Left: z, Right: &Ap{Left: y, Right: x}}
+						return ev.ap(z, ev.ap(y, x))
 					case "cons":
-						return &Ap{Left: &Ap{Left: x, Right: z}, Right: y}
+						return ev.ap(ev.ap(x, z), y)
 					}
 				}
 			}
@@ -186,61 +360,3 @@ func tryEval(expr Expr, symbols map[Symbol]Expr) Expr {
 	}
 	return expr
 }
-
-type Pair struct {
-	Left  interface{}
-	Right interface{}
-}
-
-func toValue(expr Expr) interface{} {
-	switch e := expr.(type) {
-	case Number:
-		return int64(e)
-	case Symbol:
-		if e == "nil" {
-			return []interface{}(nil)
-		}
-		panic(fmt.Sprintf("unexpected symbol: %s", e))
-	case *Ap:
-		switch e2 := e.Left.(type) {
-		case *Ap:
-			switch e3 := e2.Left.(type) {
-			case Symbol:
-				switch e3 {
-				case "cons":
-					right := toValue(e.Right)
-					left := toValue(e2.Right)
-					if rightarr, ok := right.([]interface{}); ok {
-						return append([]interface{}{left}, rightarr...)
-					} else {
-						return Pair{Left: left, Right: right}
-					}
-				default:
-					panic(fmt.Sprintf("unexpected Ap.Left: %s", printExpr(e2.Left)))
-				}
-			default:
-				panic(fmt.Sprintf("unexpected Ap.Left: %s", printExpr(e2.Left)))
-			}
-		default:
-			panic(fmt.Sprintf("unexpected Ap.Left: %s", printExpr(e.Left)))
-		}
-	default:
-		panic(fmt.Sprintf("unexpected expr type: %T", expr))
-	}
-}
-
-func valueToExpr(value interface{}) Expr {
-	switch v := value.(type) {
-	case int64:
-		return Number(v)
-	case Pair:
-		return &Ap{Left: &Ap{Left: Symbol("cons"), Right: valueToExpr(v.Left)}, Right: valueToExpr(v.Right)}
-	case []interface{}:
-		var result Expr = Symbol("nil")
-		for i := len(v) - 1; i >= 0; i-- {
-			result = &Ap{Left: &Ap{Left: Symbol("cons"), Right: valueToExpr(v[i])}, Right: result}
-		}
-		return result
-	}
-	return Symbol("nil")
-}
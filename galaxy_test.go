@@ -94,42 +94,42 @@ func TestGalaxy(t *testing.T) {
 	assert.Equal(t, "ap ap cons 0 ap ap cons ap ap cons 0 ap ap cons ap ap cons 0 nil ap ap cons 0 ap ap cons nil nil ap ap cons ap ap cons ap ap cons ap ap cons -1 -3 ap ap cons ap ap cons 0 -3 ap ap cons ap ap cons 1 -3 ap ap cons ap ap cons 2 -2 ap ap cons ap ap cons -2 -1 ap ap cons ap ap cons -1 -1 ap ap cons ap ap cons 0 -1 ap ap cons ap ap cons 3 -1 ap ap cons ap ap cons -3 0 ap ap cons ap ap cons -1 0 ap ap cons ap ap cons 1 0 ap ap cons ap ap cons 3 0 ap ap cons ap ap cons -3 1 ap ap cons ap ap cons 0 1 ap ap cons ap ap cons 1 1 ap ap cons ap ap cons 2 1 ap ap cons ap ap cons -2 2 ap ap cons ap ap cons -1 3 ap ap cons ap ap cons 0 3 ap ap cons ap ap cons 1 3 nil ap ap cons ap ap cons ap ap cons -7 -3 ap ap cons ap ap cons -8 -2 nil ap ap cons nil nil nil", printExpr(v))
 	raw := toValue(v)
 
-	expected := []interface{}{
-		int64(0),
-		[]interface{}{
-			int64(0),
-			[]interface{}{int64(0)},
-			int64(0),
-			[]interface{}(nil),
+	expected := List{
+		Int(0),
+		List{
+			Int(0),
+			List{Int(0)},
+			Int(0),
+			Nil{},
 		},
-		[]interface{}{
-			[]interface{}{
-				struct{ Left, Right interface{} }{Left: int64(-1), Right: int64(-3)},
-				struct{ Left, Right interface{} }{Left: int64(0), Right: int64(-3)},
-				struct{ Left, Right interface{} }{Left: int64(1), Right: int64(-3)},
-				struct{ Left, Right interface{} }{Left: int64(2), Right: int64(-2)},
-				struct{ Left, Right interface{} }{Left: int64(-2), Right: int64(-1)},
-				struct{ Left, Right interface{} }{Left: int64(-1), Right: int64(-1)},
-				struct{ Left, Right interface{} }{Left: int64(0), Right: int64(-1)},
-				struct{ Left, Right interface{} }{Left: int64(3), Right: int64(-1)},
-				struct{ Left, Right interface{} }{Left: int64(-3), Right: int64(0)},
-				struct{ Left, Right interface{} }{Left: int64(-1), Right: int64(0)},
-				struct{ Left, Right interface{} }{Left: int64(1), Right: int64(0)},
-				struct{ Left, Right interface{} }{Left: int64(3), Right: int64(0)},
-				struct{ Left, Right interface{} }{Left: int64(-3), Right: int64(1)},
-				struct{ Left, Right interface{} }{Left: int64(0), Right: int64(1)},
-				struct{ Left, Right interface{} }{Left: int64(1), Right: int64(1)},
-				struct{ Left, Right interface{} }{Left: int64(2), Right: int64(1)},
-				struct{ Left, Right interface{} }{Left: int64(-2), Right: int64(2)},
-				struct{ Left, Right interface{} }{Left: int64(-1), Right: int64(3)},
-				struct{ Left, Right interface{} }{Left: int64(0), Right: int64(3)},
-				struct{ Left, Right interface{} }{Left: int64(1), Right: int64(3)},
+		List{
+			List{
+				Cons{Int(-1), Int(-3)},
+				Cons{Int(0), Int(-3)},
+				Cons{Int(1), Int(-3)},
+				Cons{Int(2), Int(-2)},
+				Cons{Int(-2), Int(-1)},
+				Cons{Int(-1), Int(-1)},
+				Cons{Int(0), Int(-1)},
+				Cons{Int(3), Int(-1)},
+				Cons{Int(-3), Int(0)},
+				Cons{Int(-1), Int(0)},
+				Cons{Int(1), Int(0)},
+				Cons{Int(3), Int(0)},
+				Cons{Int(-3), Int(1)},
+				Cons{Int(0), Int(1)},
+				Cons{Int(1), Int(1)},
+				Cons{Int(2), Int(1)},
+				Cons{Int(-2), Int(2)},
+				Cons{Int(-1), Int(3)},
+				Cons{Int(0), Int(3)},
+				Cons{Int(1), Int(3)},
 			},
-			[]interface{}{
-				struct{ Left, Right interface{} }{Left: int64(-7), Right: int64(-3)},
-				struct{ Left, Right interface{} }{Left: int64(-8), Right: int64(-2)},
+			List{
+				Cons{Int(-7), Int(-3)},
+				Cons{Int(-8), Int(-2)},
 			},
-			[]interface{}(nil),
+			Nil{},
 		},
 	}
 
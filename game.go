@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lukehoban/icfp2020/physics"
+)
+
+// Request and command tags of the alien game protocol.
+const (
+	requestCreate   = 1
+	requestJoin     = 2
+	requestStart    = 3
+	requestCommands = 4
+
+	commandAccelerate = 0
+	commandDetonate   = 1
+	commandShoot      = 2
+	commandFork       = 3
+)
+
+// Game stages.
+const (
+	StageNotStarted = 0
+	StageStarted    = 1
+	StageFinished   = 2
+)
+
+// Player roles.
+const (
+	RoleAttacker = 0
+	RoleDefender = 1
+)
+
+// errGameRequest is returned when the game server answers a request with
+// [0], which is all it says about bad requests.
+var errGameRequest = errors.New("game server rejected the request")
+
+// Vec is a position, velocity or direction. It is sent as a pair.
+type Vec struct {
+	X int64 `galaxy:"0" json:"x"`
+	Y int64 `galaxy:"1" json:"y"`
+}
+
+func (v Vec) galaxyValue() Value {
+	return Cons{Int(v.X), Int(v.Y)}
+}
+
+// ShipParams are the resources of a ship, chosen when a player starts.
+type ShipParams struct {
+	Fuel    int64 `galaxy:"0" json:"fuel"`
+	Laser   int64 `galaxy:"1" json:"laser"`
+	Cooling int64 `galaxy:"2" json:"cooling"`
+	Copies  int64 `galaxy:"3" json:"copies"`
+}
+
+// PlanetInfo gives the half sizes of the square planet and of the safe
+// zone around it.
+type PlanetInfo struct {
+	Radius     int64 `galaxy:"0" json:"radius"`
+	SafeRadius int64 `galaxy:"1" json:"safeRadius"`
+}
+
+// GameInfo describes a game and doesn't change while it runs. Limits holds
+// the maximum total cost of the ship parameters, the maximum laser power
+// and the maximum cooling. Opponent is only known to the attacker.
+type GameInfo struct {
+	MaxTicks int64       `galaxy:"0" json:"maxTicks"`
+	Role     int64       `galaxy:"1" json:"role"`
+	Limits   []int64     `galaxy:"2" json:"limits"`
+	Planet   *PlanetInfo `galaxy:"3" json:"planet"`
+	Opponent *ShipParams `galaxy:"4" json:"opponent"`
+}
+
+// Ship is one ship as the game state reports it.
+type Ship struct {
+	Role     int64      `galaxy:"0" json:"role"`
+	ID       int64      `galaxy:"1" json:"id"`
+	Position Vec        `galaxy:"2" json:"position"`
+	Velocity Vec        `galaxy:"3" json:"velocity"`
+	Params   ShipParams `galaxy:"4" json:"params"`
+	Heat     int64      `galaxy:"5" json:"heat"`
+	MaxHeat  int64      `galaxy:"6" json:"maxHeat"`
+	MaxBoost int64      `galaxy:"7" json:"maxBoost"`
+}
+
+// ShipState is a ship together with the commands applied to it in the last
+// tick, left as values since their shapes differ by command.
+type ShipState struct {
+	Ship     Ship    `galaxy:"0" json:"ship"`
+	Commands []Value `galaxy:"1" json:"commands"`
+}
+
+// GameState is the state of a running game. Bound repeats the sizes of
+// the planet and the safe zone.
+type GameState struct {
+	Tick  int64       `galaxy:"0" json:"tick"`
+	Bound *PlanetInfo `galaxy:"1" json:"bound"`
+	Ships []ShipState `galaxy:"2" json:"ships"`
+}
+
+// GameResponse is the answer to join, start and commands requests. State
+// is nil until the game has started.
+type GameResponse struct {
+	Stage int64      `galaxy:"1" json:"stage"`
+	Info  GameInfo   `galaxy:"2" json:"info"`
+	State *GameState `galaxy:"3" json:"state"`
+}
+
+// ShipsOf returns the ships of one role.
+func (s *GameState) ShipsOf(role int64) []Ship {
+	var ships []Ship
+	if s == nil {
+		return ships
+	}
+	for _, ss := range s.Ships {
+		if ss.Ship.Role == role {
+			ships = append(ships, ss.Ship)
+		}
+	}
+	return ships
+}
+
+// physics returns the ship as the physics package sees it.
+func (s Ship) physics() physics.Ship {
+	return physics.Ship{
+		Role:     s.Role,
+		ID:       s.ID,
+		Position: physics.Vec(s.Position),
+		Velocity: physics.Vec(s.Velocity),
+		Params:   physics.Params(s.Params),
+		Heat:     s.Heat,
+		MaxHeat:  s.MaxHeat,
+		MaxBoost: s.MaxBoost,
+	}
+}
+
+// rules returns the physics of a game around planet.
+func (planet PlanetInfo) rules() physics.Rules {
+	return physics.Rules{PlanetRadius: planet.Radius, SafeRadius: planet.SafeRadius}
+}
+
+// rules returns the physics of the game, or of the stand-in's when the
+// planet isn't known.
+func (info GameInfo) rules() physics.Rules {
+	if info.Planet == nil {
+		return standInPlanet.rules()
+	}
+	return info.Planet.rules()
+}
+
+// Command is an order for one ship.
+type Command interface {
+	command() Value
+}
+
+// Accelerate changes the velocity of a ship by minus Vector, using fuel.
+type Accelerate struct {
+	ShipID int64
+	Vector Vec
+}
+
+// Detonate blows a ship up, damaging ships around it.
+type Detonate struct {
+	ShipID int64
+}
+
+// Shoot fires the laser of a ship at a point.
+type Shoot struct {
+	ShipID int64
+	Target Vec
+	Power  int64
+}
+
+// Fork splits off a new ship with some of the resources of a ship.
+type Fork struct {
+	ShipID int64
+	Params ShipParams
+}
+
+func (c Accelerate) command() Value {
+	return List{Int(commandAccelerate), Int(c.ShipID), c.Vector.galaxyValue()}
+}
+
+func (c Detonate) command() Value {
+	return List{Int(commandDetonate), Int(c.ShipID)}
+}
+
+func (c Shoot) command() Value {
+	return List{Int(commandShoot), Int(c.ShipID), c.Target.galaxyValue(), Int(c.Power)}
+}
+
+func (c Fork) command() Value {
+	return List{Int(commandFork), Int(c.ShipID), paramsValue(c.Params)}
+}
+
+func paramsValue(p ShipParams) Value {
+	return List{Int(p.Fuel), Int(p.Laser), Int(p.Cooling), Int(p.Copies)}
+}
+
+// GameClient plays the alien game through a Sender, such as an HTTPSender
+// that carries requests and responses through the modem.
+type GameClient struct {
+	sender Sender
+}
+
+func newGameClient(sender Sender) *GameClient {
+	return &GameClient{sender: sender}
+}
+
+// request sends a request and returns the response when it starts with 1.
+func (c *GameClient) request(ctx context.Context, request List) (Value, error) {
+	response, err := c.sender.Send(ctx, request.ToExpr())
+	if err != nil {
+		return nil, err
+	}
+	v := toValue(response)
+	head, _, err := v.AsPair()
+	if err != nil {
+		return nil, fmt.Errorf("malformed game response: %w", err)
+	}
+	if status, err := head.AsInt(); err != nil || status != 1 {
+		return nil, errGameRequest
+	}
+	return v, nil
+}
+
+func (c *GameClient) gameRequest(ctx context.Context, request List) (GameResponse, error) {
+	v, err := c.request(ctx, request)
+	if err != nil {
+		return GameResponse{}, err
+	}
+	var resp GameResponse
+	if err := decodeValue(v, &resp); err != nil {
+		return GameResponse{}, fmt.Errorf("malformed game response: %w", err)
+	}
+	return resp, nil
+}
+
+// Create creates a game and returns the player keys of its attacker and
+// defender.
+func (c *GameClient) Create(ctx context.Context) (attacker, defender int64, err error) {
+	v, err := c.request(ctx, List{Int(requestCreate)})
+	if err != nil {
+		return 0, 0, err
+	}
+	var resp struct {
+		Players []struct {
+			Role int64 `galaxy:"0"`
+			Key  int64 `galaxy:"1"`
+		} `galaxy:"1"`
+	}
+	if err := decodeValue(v, &resp); err != nil {
+		return 0, 0, fmt.Errorf("malformed game response: %w", err)
+	}
+	for _, p := range resp.Players {
+		switch p.Role {
+		case RoleAttacker:
+			attacker = p.Key
+		case RoleDefender:
+			defender = p.Key
+		}
+	}
+	return attacker, defender, nil
+}
+
+// Join joins the game of a player key.
+func (c *GameClient) Join(ctx context.Context, key int64) (GameResponse, error) {
+	return c.gameRequest(ctx, List{Int(requestJoin), Int(key), Nil{}})
+}
+
+// Start chooses the parameters of the player's first ship.
+func (c *GameClient) Start(ctx context.Context, key int64, params ShipParams) (GameResponse, error) {
+	return c.gameRequest(ctx, List{Int(requestStart), Int(key), paramsValue(params)})
+}
+
+// Commands sends the commands of the player for this tick and returns the
+// state after it.
+func (c *GameClient) Commands(ctx context.Context, key int64, commands ...Command) (GameResponse, error) {
+	var list Value = Nil{}
+	if len(commands) > 0 {
+		items := make(List, len(commands))
+		for i, cmd := range commands {
+			items[i] = cmd.command()
+		}
+		list = items
+	}
+	return c.gameRequest(ctx, List{Int(requestCommands), Int(key), list})
+}
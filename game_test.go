@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lukehoban/icfp2020/physics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandEncoding(t *testing.T) {
+	for _, test := range []struct {
+		cmd  Command
+		want string
+	}{
+		{Accelerate{ShipID: 1, Vector: Vec{X: -1, Y: 1}}, "[0 1 {-1 1}]"},
+		{Detonate{ShipID: 2}, "[1 2]"},
+		{Shoot{ShipID: 0, Target: Vec{X: 3, Y: 4}, Power: 16}, "[2 0 {3 4} 16]"},
+		{Fork{ShipID: 0, Params: ShipParams{Fuel: 8, Copies: 1}}, "[3 0 [8 0 0 1]]"},
+	} {
+		assert.Equal(t, test.want, test.cmd.command().String())
+	}
+}
+
+func TestGameResponseRoundTrip(t *testing.T) {
+	planet := &PlanetInfo{Radius: 16, SafeRadius: 128}
+	resp := GameResponse{
+		Stage: StageStarted,
+		Info:  GameInfo{MaxTicks: 256, Role: RoleDefender, Limits: []int64{448, 64, 128}, Planet: planet},
+		State: &GameState{Tick: 3, Bound: planet, Ships: []ShipState{{
+			Ship:     Ship{Role: RoleDefender, ID: 1, Position: Vec{X: 48, Y: 47}, Velocity: Vec{Y: -1}, Params: ShipParams{Fuel: 100, Copies: 1}, MaxHeat: 64, MaxBoost: 2},
+			Commands: []Value{List{Int(commandAccelerate), Cons{Int(0), Int(1)}}},
+		}}},
+	}
+	state, err := encodeValue(resp.State)
+	require.NoError(t, err)
+	info, err := encodeValue(resp.Info)
+	require.NoError(t, err)
+	v := List{Int(1), Int(resp.Stage), info, state}
+
+	var got GameResponse
+	require.NoError(t, decodeValue(toValue(v.ToExpr()), &got))
+	assert.Equal(t, resp, got)
+}
+
+func TestGameAgainstStandIn(t *testing.T) {
+	previous := aliens
+	aliens = newStandIn(7)
+	t.Cleanup(func() { aliens = previous })
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	client := newGameClient(HTTPSender{URL: srv.URL + "/aliens/send"})
+	ctx := context.Background()
+
+	attacker, defender, err := client.Create(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(t, attacker, defender)
+
+	joined, err := client.Join(ctx, attacker)
+	require.NoError(t, err)
+	assert.Equal(t, int64(StageNotStarted), joined.Stage)
+	assert.Equal(t, int64(RoleAttacker), joined.Info.Role)
+	assert.Nil(t, joined.State)
+	_, err = client.Join(ctx, defender)
+	require.NoError(t, err)
+
+	// Start blocks until both players started.
+	var wg sync.WaitGroup
+	var started [2]GameResponse
+	var errs [2]error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		started[0], errs[0] = client.Start(ctx, attacker, ShipParams{Fuel: 100, Laser: 16, Cooling: 8, Copies: 1})
+	}()
+	go func() {
+		defer wg.Done()
+		started[1], errs[1] = client.Start(ctx, defender, ShipParams{Fuel: 100, Laser: 16, Cooling: 8, Copies: 1})
+	}()
+	wg.Wait()
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	assert.Equal(t, int64(StageStarted), started[0].Stage)
+	require.NotNil(t, started[0].Info.Opponent)
+	assert.Equal(t, int64(100), started[0].Info.Opponent.Fuel)
+	assert.Nil(t, started[1].Info.Opponent)
+	require.Len(t, started[1].State.ShipsOf(RoleDefender), 1)
+	ship := started[1].State.ShipsOf(RoleDefender)[0]
+	assert.Equal(t, Vec{X: 48, Y: 48}, ship.Position)
+
+	// The defender accelerates against gravity, which cancels out on X.
+	wg.Add(2)
+	var ticked [2]GameResponse
+	go func() {
+		defer wg.Done()
+		ticked[0], errs[0] = client.Commands(ctx, attacker)
+	}()
+	go func() {
+		defer wg.Done()
+		ticked[1], errs[1] = client.Commands(ctx, defender, Accelerate{ShipID: ship.ID, Vector: Vec{X: -1}})
+	}()
+	wg.Wait()
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	assert.Equal(t, int64(1), ticked[1].State.Tick)
+	moved := ticked[1].State.Ships[1]
+	assert.Equal(t, Vec{X: 48, Y: 47}, moved.Ship.Position)
+	assert.Equal(t, Vec{X: 0, Y: -1}, moved.Ship.Velocity)
+	assert.Equal(t, int64(99), moved.Ship.Params.Fuel)
+	assert.Equal(t, []Value{List{Int(commandAccelerate), Cons{Int(-1), Int(0)}}}, moved.Commands)
+
+	// Starting twice is rejected, as is an unknown key.
+	_, err = client.Start(ctx, attacker, ShipParams{Copies: 1})
+	assert.ErrorIs(t, err, errGameRequest)
+	_, err = client.Join(ctx, 12345)
+	assert.ErrorIs(t, err, errGameRequest)
+}
+
+func TestStandInRejectsExpensiveShips(t *testing.T) {
+	s := newStandIn(1)
+	client := newGameClient(s)
+	ctx := context.Background()
+	attacker, _, err := client.Create(ctx)
+	require.NoError(t, err)
+	_, err = client.Join(ctx, attacker)
+	require.NoError(t, err)
+	_, err = client.Start(ctx, attacker, ShipParams{Fuel: 500, Laser: 10, Copies: 1})
+	assert.ErrorIs(t, err, errGameRequest)
+}
+
+func TestEventValue(t *testing.T) {
+	for _, test := range []struct {
+		event physics.Event
+		want  string
+	}{
+		{physics.Event{Command: physics.Command{Kind: physics.Accelerate, Vector: physics.Vec{X: 1, Y: -1}}}, "[0 {1 -1}]"},
+		{physics.Event{Command: physics.Command{Kind: physics.Detonate, Power: 97}}, "[1 97]"},
+		{physics.Event{Command: physics.Command{Kind: physics.Shoot, Vector: physics.Vec{X: 3, Y: 4}, Power: 40}, Damage: 6}, "[2 {3 4} 40 6]"},
+		{physics.Event{Command: physics.Command{Kind: physics.Fork, Params: physics.Params{Fuel: 5, Copies: 1}}}, "[3 [5 0 0 1]]"},
+	} {
+		assert.Equal(t, test.want, eventValue(test.event).String())
+	}
+}
+
+func TestStandInDropsGames(t *testing.T) {
+	s := newStandIn(1)
+	s.tickTimeout = time.Millisecond
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	client := newGameClient(s)
+	ctx := context.Background()
+
+	// An abandoned game is dropped after standInGameTTL without requests.
+	attacker, _, err := client.Create(ctx)
+	require.NoError(t, err)
+	now = now.Add(standInGameTTL / 2)
+	_, err = client.Join(ctx, attacker)
+	require.NoError(t, err)
+	now = now.Add(standInGameTTL)
+	_, err = client.Join(ctx, attacker)
+	assert.ErrorIs(t, err, errGameRequest)
+
+	// A finished game is dropped standInFinishedTTL after it finished.
+	attacker, defender, err := client.Create(ctx)
+	require.NoError(t, err)
+	assert.Len(t, s.games, 2)
+	for _, key := range []int64{attacker, defender} {
+		_, err = client.Join(ctx, key)
+		require.NoError(t, err)
+		_, err = client.Start(ctx, key, ShipParams{Fuel: 100, Laser: 16, Cooling: 8, Copies: 1})
+		require.NoError(t, err)
+	}
+	ship := s.games[attacker].shipsOf(RoleAttacker)[0]
+	finished, err := client.Commands(ctx, attacker, Detonate{ShipID: ship.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(StageFinished), finished.Stage)
+	now = now.Add(standInFinishedTTL - time.Second)
+	_, err = client.Commands(ctx, defender)
+	require.NoError(t, err)
+	now = now.Add(time.Second)
+	_, err = client.Commands(ctx, defender)
+	assert.ErrorIs(t, err, errGameRequest)
+
+	// Only standInMaxGames can be in play at once.
+	for range standInMaxGames {
+		_, _, err = client.Create(ctx)
+		require.NoError(t, err)
+	}
+	assert.Len(t, s.games, 2*standInMaxGames)
+	_, _, err = client.Create(ctx)
+	assert.ErrorIs(t, err, errGameRequest)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// depGraph is the dependency graph between the definitions of a program.
+// Only edges to symbols the program defines are kept, so builtins such as
+// cons don't appear.
+type depGraph struct {
+	nodes   []Symbol
+	edges   map[Symbol][]Symbol
+	reverse map[Symbol][]Symbol
+}
+
+func sortSymbols(symbols []Symbol) {
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i] < symbols[j] })
+}
+
+func buildGraph(symbols map[Symbol]Expr) *depGraph {
+	g := &depGraph{edges: map[Symbol][]Symbol{}, reverse: map[Symbol][]Symbol{}}
+	for name, expr := range symbols {
+		g.nodes = append(g.nodes, name)
+		for _, ref := range references(expr) {
+			if _, ok := symbols[ref]; ok {
+				g.edges[name] = append(g.edges[name], ref)
+				g.reverse[ref] = append(g.reverse[ref], name)
+			}
+		}
+	}
+	sortSymbols(g.nodes)
+	for _, m := range []map[Symbol][]Symbol{g.edges, g.reverse} {
+		for _, targets := range m {
+			sortSymbols(targets)
+		}
+	}
+	return g
+}
+
+// components returns the strongly connected components of the graph that
+// form recursive groups: those with more than one symbol, or a single
+// symbol that refers to itself. Each component and the list are sorted.
+func (g *depGraph) components() [][]Symbol {
+	// Tarjan's algorithm.
+	index := map[Symbol]int{}
+	lowlink := map[Symbol]int{}
+	onStack := map[Symbol]bool{}
+	var stack []Symbol
+	var result [][]Symbol
+
+	var strongConnect func(v Symbol)
+	strongConnect = func(v Symbol) {
+		index[v] = len(index)
+		lowlink[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range g.edges[v] {
+			if _, visited := index[w]; !visited {
+				strongConnect(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+
+		if lowlink[v] == index[v] {
+			var component []Symbol
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			if len(component) > 1 || g.refersTo(v, v) {
+				sortSymbols(component)
+				result = append(result, component)
+			}
+		}
+	}
+
+	for _, v := range g.nodes {
+		if _, visited := index[v]; !visited {
+			strongConnect(v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
+
+func (g *depGraph) refersTo(from, to Symbol) bool {
+	for _, s := range g.edges[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// dot renders the graph in Graphviz format, with each recursive group drawn
+// as a cluster.
+func (g *depGraph) dot(name string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digraph %q {\n", name)
+	for i, component := range g.components() {
+		fmt.Fprintf(&sb, "  subgraph cluster_%d {\n    style=dashed;\n", i)
+		for _, s := range component {
+			fmt.Fprintf(&sb, "    %q;\n", string(s))
+		}
+		sb.WriteString("  }\n")
+	}
+	for _, from := range g.nodes {
+		if len(g.edges[from]) == 0 {
+			fmt.Fprintf(&sb, "  %q;\n", string(from))
+		}
+		for _, to := range g.edges[from] {
+			fmt.Fprintf(&sb, "  %q -> %q;\n", string(from), string(to))
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func symbolStrings(symbols []Symbol) []string {
+	result := make([]string, len(symbols))
+	for i, s := range symbols {
+		result[i] = string(s)
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	symbols, err := parseProgramText(strings.Join([]string{
+		"even = ap ap s ap eq 0 ap ap b odd ap add -1",
+		"odd = ap ap s ap eq 1 ap ap b even ap add -1",
+		"loop = ap loop 1",
+		"main = ap even ap twice 3",
+		"twice = ap mul 2",
+	}, "\n"))
+	require.NoError(t, err)
+	g := buildGraph(symbols)
+
+	assert.Equal(t, []Symbol{"even", "twice"}, g.edges["main"])
+	assert.Equal(t, []Symbol{"main", "odd"}, g.reverse["even"])
+	assert.Equal(t, [][]Symbol{{"even", "odd"}, {"loop"}}, g.components())
+	assert.Equal(t, `digraph "test" {
+  subgraph cluster_0 {
+    style=dashed;
+    "even";
+    "odd";
+  }
+  subgraph cluster_1 {
+    style=dashed;
+    "loop";
+  }
+  "even" -> "odd";
+  "loop" -> "loop";
+  "main" -> "even";
+  "main" -> "twice";
+  "odd" -> "even";
+  "twice";
+}
+`, g.dot("test"))
+}
+
+func TestGalaxyGraphEndpoints(t *testing.T) {
+	rr := serve(http.MethodGet, "/programs/galaxy/symbols/galaxy", "")
+	assert.JSONEq(t, `{"program": "galaxy", "name": "galaxy", "definition": ":1338", "references": [":1338"], "referencedBy": []}`, rr.Body.String())
+
+	rr = serve(http.MethodGet, "/programs/galaxy/graph", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var graph GraphResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &graph))
+	assert.Len(t, graph.Nodes, 393)
+	assert.NotEmpty(t, graph.Components)
+
+	rr = serve(http.MethodGet, "/programs/galaxy/graph?format=dot", "")
+	assert.Equal(t, "text/vnd.graphviz", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `"galaxy" -> ":1338";`)
+
+	rr = serve(http.MethodGet, "/programs/galaxy/graph?format=svg", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// defaultReadyTimeout bounds the smoke test of /readyz.
+const defaultReadyTimeout = 5 * time.Second
+
+// readyTimeout bounds the smoke test of /readyz.
+var readyTimeout = defaultReadyTimeout
+
+type HealthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthzHandler reports that the server is alive: it answers whenever the
+// process can serve requests at all.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(HealthResponse{Error: "Method not allowed"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+}
+
+// readyzHandler reports whether the default program loaded and works, by
+// clicking at the origin of its initial screen like the web UI does, under
+// readyTimeout. It answers 503 until that draws something.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(HealthResponse{Error: "Method not allowed"})
+		return
+	}
+
+	prog, ok := programs.get(defaultProgram)
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{Status: "not ready", Error: "No program loaded"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(prog.context(r.Context()), readyTimeout)
+	defer cancel()
+	if err := smokeTest(ctx, prog.symbols); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{Status: "not ready", Error: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ready"})
+}
+
+// smokeTest evaluates galaxy at the initial state with a click at the
+// origin and checks that it draws a screen without sending anything.
+func smokeTest(ctx context.Context, symbols map[Symbol]Expr) error {
+	if _, ok := symbols[Symbol("galaxy")]; !ok {
+		return errors.New("program has no galaxy symbol")
+	}
+	expr := &Ap{
+		Left:  &Ap{Left: Symbol("galaxy"), Right: Symbol("nil")},
+		Right: &Ap{Left: &Ap{Left: cons, Right: Number(0)}, Right: Number(0)},
+	}
+	flag, _, data, err := decodeInteractResult(newEvaluator(ctx, symbols).view(expr))
+	if err != nil {
+		return err
+	}
+	if flag != 0 {
+		return fmt.Errorf("initial click returned flag %d, want 0", flag)
+	}
+	images, err := decodeImages(data)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		return errors.New("initial click drew nothing")
+	}
+	return nil
+}
+
+// ProgramVersion identifies the program a server evaluates.
+type ProgramVersion struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Symbols int    `json:"symbols"`
+}
+
+// VersionResponse reports how the server was built and what it runs.
+// Revision and the fields after it come from version control and are empty
+// when the binary was built without it.
+type VersionResponse struct {
+	GoVersion    string          `json:"goVersion"`
+	Module       string          `json:"module"`
+	Version      string          `json:"version"`
+	Revision     string          `json:"revision,omitempty"`
+	RevisionTime string          `json:"revisionTime,omitempty"`
+	Modified     bool            `json:"modified,omitempty"`
+	Program      *ProgramVersion `json:"program,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// buildVersion reads the build info embedded in the binary.
+func buildVersion() VersionResponse {
+	var resp VersionResponse
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return resp
+	}
+	resp.GoVersion = info.GoVersion
+	resp.Module = info.Main.Path
+	resp.Version = info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			resp.Revision = setting.Value
+		case "vcs.time":
+			resp.RevisionTime = setting.Value
+		case "vcs.modified":
+			resp.Modified = setting.Value == "true"
+		}
+	}
+	return resp
+}
+
+// versionHandler reports the build of the server and the default program.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(VersionResponse{Error: "Method not allowed"})
+		return
+	}
+
+	resp := buildVersion()
+	if p, ok := programs.get(defaultProgram); ok {
+		resp.Program = &ProgramVersion{Name: p.name, Path: p.path, SHA256: p.hash, Symbols: len(p.symbols)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthz(t *testing.T) {
+	rr := serve(http.MethodGet, "/healthz", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, rr.Body.String())
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPost, "/healthz", "").Code)
+}
+
+func TestReadyz(t *testing.T) {
+	rr := serve(http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status": "ready"}`, rr.Body.String())
+
+	previous, ok := programs.get(defaultProgram)
+	require.True(t, ok)
+	t.Cleanup(func() {
+		programs.mu.Lock()
+		programs.programs[defaultProgram] = previous
+		programs.mu.Unlock()
+	})
+	symbols, err := parseProgramText("main = 1\n")
+	require.NoError(t, err)
+	programs.add(defaultProgram, symbols)
+	rr = serve(http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"status": "not ready", "error": "program has no galaxy symbol"}`, rr.Body.String())
+
+	programs.remove(defaultProgram)
+	rr = serve(http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"status": "not ready", "error": "No program loaded"}`, rr.Body.String())
+}
+
+func TestSmokeTest(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		err     string
+	}{
+		{"draws", "galaxy = ap t ap t ap ap cons 0 ap ap cons nil ap ap cons ap ap cons ap ap cons ap ap cons 1 2 nil nil nil", ""},
+		{"sends", "galaxy = ap t ap t ap ap cons 1 ap ap cons nil ap ap cons nil nil", "initial click returned flag 1, want 0"},
+		{"blank", "galaxy = ap t ap t ap ap cons 0 ap ap cons nil ap ap cons nil nil", "initial click drew nothing"},
+		{"malformed", "galaxy = ap t ap t 5", errBadInteractResult.Error()},
+		{"loops", "galaxy = ap t ap t ap ap ap s i i ap ap s i i", errEvalTimeout.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbols, err := parseProgramText(tt.program + "\n")
+			require.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err = smokeTest(ctx, symbols)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	rr := serve(http.MethodGet, "/version", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp VersionResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+	source, err := os.ReadFile("galaxy.txt")
+	require.NoError(t, err)
+	sum := sha256.Sum256(source)
+	symbols, err := parseProgram("galaxy.txt")
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.GoVersion)
+	assert.Equal(t, &ProgramVersion{Name: defaultProgram, Path: "galaxy.txt", SHA256: hex.EncodeToString(sum[:]), Symbols: len(symbols)}, resp.Program)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodDelete, "/version", "").Code)
+}
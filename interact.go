@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// errBadInteractResult is returned when galaxy produces something other than
+// a [flag, state, data] list.
+var errBadInteractResult = errors.New("Failed to process interaction result")
+
+// errSendFailed wraps the errors of sending data to the aliens.
+var errSendFailed = errors.New("send failed")
+
+// maxSendRoundTrips bounds how many times one interaction may send data to
+// the aliens before giving up.
+const maxSendRoundTrips = 64
+
+// InteractEvent reports the progress of an interaction. Type is one of
+// "started" (galaxy is being evaluated), "flag" (galaxy returned), "send"
+// (data is being sent to the aliens), "received" (the aliens answered),
+// "image" (one decoded image layer) and "done" (Result holds the response).
+// Flag is set on "flag" events and Layer on "image" events, even when zero.
+type InteractEvent struct {
+	Type   string            `json:"type"`
+	Round  int               `json:"round"`
+	Flag   *int64            `json:"flag,omitempty"`
+	Data   string            `json:"data,omitempty"`
+	Layer  *int              `json:"layer,omitempty"`
+	Image  []PointPair       `json:"image,omitempty"`
+	Result *InteractResponse `json:"result,omitempty"`
+}
+
+// interact calls the galaxy function of symbols with a state and a clicked
+// point and decodes its [flag, newState, images] result, running the full
+// interaction protocol.
+func interact(ctx context.Context, symbols map[Symbol]Expr, stateExpr Expr, x, y int64) (InteractResponse, error) {
+	return interactWithEvents(ctx, symbols, stateExpr, x, y, true, func(InteractEvent) {})
+}
+
+// interactWithEvents calls galaxy and decodes its result. With send, it
+// runs the full interaction protocol: while galaxy returns a non-zero flag,
+// its data is sent to the aliens and their response becomes the next
+// point; without a sender, the data is returned instead of images. Without
+// send, galaxy is called once and its data is decoded as images whatever
+// the flag, as simple clients of /interact expect. emit is called as the
+// interaction progresses.
+func interactWithEvents(ctx context.Context, symbols map[Symbol]Expr, stateExpr Expr, x, y int64, send bool, emit func(InteractEvent)) (InteractResponse, error) {
+	// Create point expression: ap ap cons x y
+	var pointExpr Expr = &Ap{
+		Left: &Ap{
+			Left:  Symbol("cons"),
+			Right: Number(x),
+		},
+		Right: Number(y),
+	}
+
+	// Call galaxy function with state and point: ap ap galaxy state point
+	// Check if galaxy function exists
+	if _, exists := symbols[Symbol("galaxy")]; !exists {
+		// If galaxy function doesn't exist, return a default response
+		resp := InteractResponse{
+			NewState: printExpr(stateExpr), // Return the same state
+			Images:   [][]PointPair{},      // Empty images
+		}
+		emit(InteractEvent{Type: "done", Result: &resp})
+		return resp, nil
+	}
+
+	for round := 0; ; round++ {
+		emit(InteractEvent{Type: "started", Round: round})
+		slog.DebugContext(ctx, "interaction", "round", round, "state", loggedExpr{stateExpr})
+		interactExpr := &Ap{
+			Left: &Ap{
+				Left:  Symbol("galaxy"),
+				Right: stateExpr,
+			},
+			Right: pointExpr,
+		}
+
+		// Only the parts of the result that are needed are evaluated and
+		// converted. The new state and the data to send are normalized so
+		// that they are printed, hashed and sent as values. All rounds
+		// spend from the one budget ctx carries.
+		result := newEvaluator(ctx, symbols).view(interactExpr)
+		flag, newState, data, err := decodeInteractResult(result)
+		if err != nil {
+			return InteractResponse{}, err
+		}
+		emit(InteractEvent{Type: "flag", Round: round, Flag: &flag})
+
+		if flag == 0 || !send || sendToAliens == nil {
+			resp := InteractResponse{Flag: flag, Images: [][]PointPair{}}
+			if flag == 0 || !send {
+				if resp.Images, err = decodeImages(data); err != nil {
+					return InteractResponse{}, err
+				}
+				for i, image := range resp.Images {
+					emit(InteractEvent{Type: "image", Round: round, Layer: &i, Image: image})
+				}
+			} else {
+				sendData, err := normalizeValue(ctx, data.Expr(), symbols)
+				if err != nil {
+					return InteractResponse{}, err
+				}
+				resp.Data = printEvaluated(sendData)
+			}
+			// The state is normalized last: it is the only part that may
+			// be left partly unevaluated when the budget runs out.
+			if newState, err = normalizeValue(ctx, newState, symbols); err != nil {
+				return InteractResponse{}, err
+			}
+			resp.NewState, resp.NewStateValue = printEvaluated(newState), &TaggedValue{toValue(newState)}
+			emit(InteractEvent{Type: "done", Round: round, Result: &resp})
+			return resp, nil
+		}
+
+		if round+1 >= maxSendRoundTrips {
+			return InteractResponse{}, fmt.Errorf("interaction did not finish after %d sends", maxSendRoundTrips)
+		}
+		sendData, err := normalizeValue(ctx, data.Expr(), symbols)
+		if err != nil {
+			return InteractResponse{}, err
+		}
+		if newState, err = normalizeValue(ctx, newState, symbols); err != nil {
+			return InteractResponse{}, err
+		}
+		emit(InteractEvent{Type: "send", Round: round, Data: printEvaluated(sendData)})
+		start := time.Now()
+		response, err := sendToAliens.Send(ctx, sendData)
+		serverMetrics.observeSend(err, time.Since(start))
+		if err != nil {
+			// A send cut short by the request's deadline or cancellation
+			// fails like an evaluation would.
+			if ctx.Err() != nil {
+				err = abortError(ctx)
+			}
+			return InteractResponse{}, fmt.Errorf("%w: %w", errSendFailed, err)
+		}
+		emit(InteractEvent{Type: "received", Round: round, Data: printExpr(response)})
+		stateExpr, pointExpr = newState, response
+	}
+}
+
+// decodeInteractResult splits a galaxy result into its flag, new state and
+// data, which holds the images when the flag is 0 and the data to send
+// otherwise. Only the flag and the spine of the list are evaluated.
+func decodeInteractResult(result lazyValue) (flag int64, newState Expr, data lazyValue, err error) {
+	items, err := result.AsList()
+	if err == nil && len(items) != 3 {
+		err = &ValueError{"a list of 3", toValue(result.Expr())}
+	}
+	if err == nil {
+		flag, err = items[0].AsInt()
+	}
+	if err != nil {
+		return 0, nil, lazyValue{}, badInteractResult(err)
+	}
+	return flag, items[1].Expr(), items[2], nil
+}
+
+// decodeImages decodes the image layers galaxy draws: a list of lists of
+// points.
+func decodeImages(data lazyValue) ([][]PointPair, error) {
+	layers, err := data.AsList()
+	if err != nil {
+		return nil, badInteractResult(err)
+	}
+	images := make([][]PointPair, len(layers))
+	for i, layer := range layers {
+		points, err := layer.AsList()
+		if err != nil {
+			return nil, badInteractResult(err)
+		}
+		images[i] = make([]PointPair, len(points))
+		for j, point := range points {
+			x, y, err := point.AsPair()
+			if err == nil {
+				images[i][j].X, err = x.AsInt()
+			}
+			if err == nil {
+				images[i][j].Y, err = y.AsInt()
+			}
+			if err != nil {
+				return nil, badInteractResult(err)
+			}
+		}
+	}
+	return images, nil
+}
+
+// badInteractResult turns a shape error into errBadInteractResult and
+// passes evaluation errors through.
+func badInteractResult(err error) error {
+	var valueErr *ValueError
+	if errors.As(err, &valueErr) {
+		slog.Debug("interaction result conversion failed", "err", err)
+		return errBadInteractResult
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// senderProgram sends [42] to the aliens while its state is nil, and then
+// draws the point the aliens answered with.
+const senderProgram = `wrap = ap ap c cons nil
+imgs = ap ap b wrap wrap
+mk = ap ap b ap cons 0 ap ap b ap cons ap ap cons 1 nil ap ap b wrap imgs
+send = ap ap cons 1 ap ap cons ap ap cons 1 nil ap ap cons ap ap cons 42 nil nil
+galaxy = ap ap b ap ap c b mk ap ap c isnil send`
+
+func withSender(t *testing.T, sender Sender) {
+	previous := sendToAliens
+	sendToAliens = sender
+	t.Cleanup(func() { sendToAliens = previous })
+}
+
+func TestInteractSendsToAliens(t *testing.T) {
+	symbols, err := parseProgramText(senderProgram)
+	require.NoError(t, err)
+
+	// Without a sender the interaction stops at the first send.
+	withSender(t, nil)
+	resp, err := interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, InteractResponse{Flag: 1, NewState: "ap ap cons 1 nil", NewStateValue: &TaggedValue{List{Int(1)}}, Images: [][]PointPair{}, Data: "ap ap cons 42 nil"}, resp)
+
+	var sent []string
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		sent = append(sent, printExpr(data))
+		return &Ap{Left: &Ap{Left: cons, Right: Number(7)}, Right: Number(8)}, nil
+	}))
+	var events []string
+	resp, err = interactWithEvents(context.Background(), symbols, Symbol("nil"), 0, 0, true, func(ev InteractEvent) {
+		events = append(events, ev.Type)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ap ap cons 42 nil"}, sent)
+	assert.Equal(t, InteractResponse{Flag: 0, NewState: "ap ap cons 1 nil", NewStateValue: &TaggedValue{List{Int(1)}}, Images: [][]PointPair{{{X: 7, Y: 8}}}}, resp)
+	assert.Equal(t, "started flag send received started flag image done", strings.Join(events, " "))
+
+	// Without send, galaxy is called once and its data must be images.
+	sent = nil
+	_, err = interactWithEvents(context.Background(), symbols, Symbol("nil"), 0, 0, false, func(InteractEvent) {})
+	assert.Equal(t, errBadInteractResult, err)
+	assert.Empty(t, sent)
+
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		return nil, errors.New("link down")
+	}))
+	_, err = interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	assert.EqualError(t, err, "send failed: link down")
+}
+
+func TestInteractSharesBudgetAcrossSends(t *testing.T) {
+	// galaxy asks to send nil whatever the aliens answer.
+	symbols, err := parseProgramText("galaxy = ap t ap t ap ap cons 1 ap ap cons nil ap ap cons nil nil")
+	require.NoError(t, err)
+
+	withSender(t, nil)
+	ctx := withBudget(context.Background(), 1_000_000)
+	_, err = interact(ctx, symbols, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+	perRound := 1_000_000 - budgetFrom(ctx).remaining()
+	require.Positive(t, perRound)
+
+	sends := 0
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		sends++
+		return Symbol("nil"), nil
+	}))
+	_, err = interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	assert.EqualError(t, err, "interaction did not finish after 64 sends")
+
+	// Each round is within the budget, but all of them are not. Later
+	// rounds reuse cached values and may cost less than the first.
+	sends = 0
+	_, err = interact(withBudget(context.Background(), 3*perRound), symbols, Symbol("nil"), 0, 0)
+	assert.Equal(t, errBudgetExhausted, err)
+	assert.GreaterOrEqual(t, sends, 3)
+	assert.Less(t, sends, maxSendRoundTrips-1)
+}
+
+func TestInteractHandlerSendOptIn(t *testing.T) {
+	// galaxy asks to send [[(1, 2)]], which also reads as one image layer.
+	symbols, err := parseProgramText("galaxy = ap t ap t ap ap cons 1 ap ap cons nil ap ap cons ap ap cons ap ap cons ap ap cons 1 2 nil nil nil\n")
+	require.NoError(t, err)
+	programs.add("flagged", symbols)
+	t.Cleanup(func() { programs.remove("flagged") })
+	withSender(t, nil)
+
+	rr := serve(http.MethodPost, "/interact", `{"state": "nil", "program": "flagged", "point": {"x": 0, "y": 0}}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp InteractResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, int64(1), resp.Flag)
+	assert.Equal(t, [][]PointPair{{{X: 1, Y: 2}}}, resp.Images)
+	assert.Empty(t, resp.Data)
+
+	rr = serve(http.MethodPost, "/interact", `{"state": "nil", "program": "flagged", "point": {"x": 0, "y": 0}, "send": true}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	resp = InteractResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, int64(1), resp.Flag)
+	assert.Empty(t, resp.Images)
+	assert.Equal(t, "ap ap cons ap ap cons ap ap cons 1 2 nil nil", resp.Data)
+}
+
+func TestInteractHandlerSendErrors(t *testing.T) {
+	symbols, err := parseProgramText(senderProgram)
+	require.NoError(t, err)
+	programs.add("sender", symbols)
+	t.Cleanup(func() { programs.remove("sender") })
+	previous := evalTimeout
+	evalTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { evalTimeout = previous })
+	body := `{"program": "sender", "state": "nil", "point": {"x": 0, "y": 0}, "send": true}`
+
+	// A failing upstream is a bad gateway, one that doesn't answer in time
+	// a gateway timeout.
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		return nil, errors.New("link down")
+	}))
+	rr := serve(http.MethodPost, "/interact", body)
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"error":"send failed: link down"`)
+
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	rr = serve(http.MethodPost, "/interact", body)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"error":"send failed: evaluation timed out"`)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"runtime"
+	"sync"
+	"unsafe"
+	"weak"
+)
+
+// exprHash is a structural hash of an expression. It only depends on the
+// shape of the expression, so it is stable across processes and can key
+// caches and stored states.
+type exprHash [16]byte
+
+func (h exprHash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+func hashLeaf(tag byte, s string) exprHash {
+	sum := sha256.Sum256(append([]byte{tag}, s...))
+	return exprHash(sum[:16])
+}
+
+func hashAp(left, right exprHash) exprHash {
+	var buf [33]byte
+	buf[0] = 'a'
+	copy(buf[1:], left[:])
+	copy(buf[17:], right[:])
+	sum := sha256.Sum256(buf[:])
+	return exprHash(sum[:16])
+}
+
+// hashExpr computes the structural hash of expr without an interner. Shared
+// subexpressions are hashed once.
+func hashExpr(expr Expr) exprHash {
+	memo := map[*Ap]exprHash{}
+	var walk func(Expr) exprHash
+	walk = func(expr Expr) exprHash {
+		switch e := expr.(type) {
+		case Number:
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], uint64(e))
+			return hashLeaf('n', string(buf[:]))
+		case Symbol:
+			return hashLeaf('s', string(e))
+		case *Ap:
+			if h, ok := memo[e]; ok {
+				return h
+			}
+			h := hashAp(walk(e.Left), walk(e.Right))
+			memo[e] = h
+			return h
+		}
+		return exprHash{}
+	}
+	return walk(expr)
+}
+
+// apKey identifies an application by its children, which are themselves
+// interned, so comparing keys compares structure. Child applications are
+// keyed by address so the table doesn't keep them alive. An address can only
+// be reused once the child is collected, and by then the parent entry's
+// node is gone too, so lookups treat the stale entry as absent.
+type apKey struct {
+	left, right childKey
+}
+
+type childKey struct {
+	addr uintptr
+	leaf Expr
+}
+
+func keyOf(left, right Expr) apKey {
+	return apKey{childKeyOf(left), childKeyOf(right)}
+}
+
+func childKeyOf(expr Expr) childKey {
+	if a, ok := expr.(*Ap); ok {
+		return childKey{addr: uintptr(unsafe.Pointer(a))}
+	}
+	return childKey{leaf: expr}
+}
+
+type internEntry struct {
+	node   weak.Pointer[Ap]
+	hash   exprHash
+	hashed bool
+}
+
+// interner hash-conses expressions: within one interner, structurally equal
+// applications are the same *Ap, so equality is a pointer comparison and
+// shared structure is stored once. Numbers and symbols are values and are
+// their own canonical form. The table only holds weak references, so nodes
+// nobody else uses are still garbage collected.
+//
+// Because eval caches results in Ap.v, an interner must only be used with
+// one symbol table.
+type interner struct {
+	mu      sync.Mutex
+	entries map[apKey]*internEntry
+}
+
+func newInterner() *interner {
+	return &interner{entries: map[apKey]*internEntry{}}
+}
+
+// intern returns the canonical form of expr.
+func (in *interner) intern(expr Expr) Expr {
+	e, ok := expr.(*Ap)
+	if !ok {
+		return expr
+	}
+	in.mu.Lock()
+	canonical := in.lookup(keyOf(e.Left, e.Right)) == e
+	in.mu.Unlock()
+	if canonical {
+		return e
+	}
+	left, right := in.intern(e.Left), in.intern(e.Right)
+	if left == e.Left && right == e.Right {
+		return in.insert(e)
+	}
+	return in.ap(left, right)
+}
+
+// ap returns the canonical application of two already interned expressions.
+func (in *interner) ap(left, right Expr) *Ap {
+	in.mu.Lock()
+	if node := in.lookup(keyOf(left, right)); node != nil {
+		in.mu.Unlock()
+		return node
+	}
+	in.mu.Unlock()
+	return in.insert(&Ap{Left: left, Right: right})
+}
+
+func (in *interner) lookup(key apKey) *Ap {
+	if entry, ok := in.entries[key]; ok {
+		return entry.node.Value()
+	}
+	return nil
+}
+
+// insert makes node canonical unless another goroutine got there first.
+func (in *interner) insert(node *Ap) *Ap {
+	key := keyOf(node.Left, node.Right)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if existing := in.lookup(key); existing != nil {
+		return existing
+	}
+	entry := &internEntry{node: weak.Make(node)}
+	in.entries[key] = entry
+	runtime.AddCleanup(node, in.remove, key)
+	return node
+}
+
+// remove drops the entry for a collected node, unless the key has been
+// interned again since.
+func (in *interner) remove(key apKey) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if entry, ok := in.entries[key]; ok && entry.node.Value() == nil {
+		delete(in.entries, key)
+	}
+}
+
+// len returns the number of entries in the table.
+func (in *interner) len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.entries)
+}
+
+// hash returns the structural hash of an interned expression, computing and
+// caching the hashes of its subexpressions as needed. It equals hashExpr.
+func (in *interner) hash(expr Expr) exprHash {
+	e, ok := expr.(*Ap)
+	if !ok {
+		return hashExpr(expr)
+	}
+	in.mu.Lock()
+	entry, ok := in.entries[keyOf(e.Left, e.Right)]
+	if ok && entry.hashed {
+		in.mu.Unlock()
+		return entry.hash
+	}
+	in.mu.Unlock()
+	if !ok || entry.node.Value() != e {
+		return hashExpr(expr)
+	}
+	h := hashAp(in.hash(e.Left), in.hash(e.Right))
+	in.mu.Lock()
+	entry.hash, entry.hashed = h, true
+	in.mu.Unlock()
+	return h
+}
+
+type internerKey struct{}
+
+// withInterner makes evaluations under ctx hash-cons the expressions they
+// are given and build.
+func withInterner(ctx context.Context, in *interner) context.Context {
+	if in == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, internerKey{}, in)
+}
+
+func internerFrom(ctx context.Context) *interner {
+	in, _ := ctx.Value(internerKey{}).(*interner)
+	return in
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternSharesStructure(t *testing.T) {
+	in := newInterner()
+	a, _ := parseLine("ap ap cons 1 ap ap cons 2 nil")
+	b, _ := parseLine("ap ap cons 1 ap ap cons 2 nil")
+	c, _ := parseLine("ap ap cons 1 ap ap cons 3 nil")
+
+	ia, ib, ic := in.intern(a), in.intern(b), in.intern(c)
+	assert.Same(t, ia, ib)
+	assert.NotSame(t, ia, ic)
+	// The shared prefix "ap cons 1" is stored once.
+	assert.Same(t, ia.(*Ap).Left, ic.(*Ap).Left)
+	assert.Equal(t, 7, in.len())
+
+	assert.Equal(t, hashExpr(a), in.hash(ia))
+	assert.Equal(t, hashExpr(b), hashExpr(a))
+	assert.NotEqual(t, in.hash(ia), in.hash(ic))
+	assert.Equal(t, "fd87400839d77a6884dc3b634ce294ad", hashExpr(Number(0)).String())
+}
+
+func TestInternedEvaluation(t *testing.T) {
+	plain, err := parseProgram("galaxy.txt")
+	require.NoError(t, err)
+	expected, err := interact(context.Background(), plain, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+
+	r := newProgramRegistry()
+	r.intern = true
+	p, err := r.load(defaultProgram, "galaxy.txt")
+	require.NoError(t, err)
+	ctx := p.context(context.Background())
+
+	resp, err := interact(ctx, p.symbols, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, expected, resp)
+
+	// Evaluating the same application twice finds the interned node and its
+	// cached value.
+	expr, _ := parseLine("ap ap galaxy nil ap ap cons 0 0")
+	first, err := evalContext(ctx, expr, p.symbols)
+	require.NoError(t, err)
+	expr, _ = parseLine("ap ap galaxy nil ap ap cons 0 0")
+	second, err := evalContext(ctx, expr, p.symbols)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+}
+
+func TestInternReleasesUnusedNodes(t *testing.T) {
+	in := newInterner()
+	func() {
+		var list Expr = Symbol("nil")
+		for i := 0; i < 1000; i++ {
+			list = in.ap(in.ap(cons, Number(i)), list)
+		}
+	}()
+	assert.Equal(t, 2000, in.len())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for in.len() > 0 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, in.len())
+}
+
+// galaxySession clicks through a few screens of galaxy, feeding each new
+// state back in.
+func galaxySession(b *testing.B, ctx context.Context, symbols map[Symbol]Expr) {
+	var state Expr = Symbol("nil")
+	for _, point := range [][2]int64{{0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {8, 4}, {2, -8}, {3, 6}, {0, -14}} {
+		resp, err := interact(ctx, symbols, state, point[0], point[1])
+		if err != nil {
+			b.Fatal(err)
+		}
+		state, _ = parseLine(resp.NewState)
+	}
+}
+
+func liveHeap() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// benchmarkGalaxySession runs sessions against freshly loaded programs and
+// also reports the heap still live afterwards, which is what long sessions
+// accumulate through the Ap.v caches.
+func benchmarkGalaxySession(b *testing.B, intern bool) {
+	b.ReportAllocs()
+	r := newProgramRegistry()
+	r.intern = intern
+	var live uint64
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		before := liveHeap()
+		p, _ := r.read(defaultProgram, "galaxy.txt")
+		b.StartTimer()
+		galaxySession(b, p.context(context.Background()), p.symbols)
+		b.StopTimer()
+		live += liveHeap() - before
+		runtime.KeepAlive(p)
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(live)/float64(b.N), "live-B/op")
+}
+
+func BenchmarkGalaxySession(b *testing.B) {
+	benchmarkGalaxySession(b, false)
+}
+
+func BenchmarkGalaxySessionInterned(b *testing.B) {
+	benchmarkGalaxySession(b, true)
+}
+
+// BenchmarkBuildList compares building the same list repeatedly with fresh
+// allocations against interned nodes.
+func BenchmarkBuildList(b *testing.B) {
+	build := func(ap func(l, r Expr) *Ap) Expr {
+		var list Expr = Symbol("nil")
+		for i := 0; i < 100; i++ {
+			list = ap(ap(cons, Number(i)), list)
+		}
+		return list
+	}
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		ev := &evaluator{}
+		for i := 0; i < b.N; i++ {
+			build(ev.ap)
+		}
+	})
+	b.Run("interned", func(b *testing.B) {
+		b.ReportAllocs()
+		ev := &evaluator{intern: newInterner()}
+		keep := build(ev.ap)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			build(ev.ap)
+		}
+		runtime.KeepAlive(keep)
+	})
+}
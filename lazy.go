@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// resolve returns the value eval cached for expr, or expr itself when it
+// has not been evaluated.
+func resolve(expr Expr) Expr {
+	if a, ok := expr.(*Ap); ok && a.v != nil {
+		return a.v
+	}
+	return expr
+}
+
+// printEvaluated prints expr like printExpr, but prints the cached value of
+// every subexpression that has been evaluated, so an evaluated result can
+// be serialized without converting it to a Value first.
+func printEvaluated(expr Expr) string {
+	var sb strings.Builder
+	var write func(Expr)
+	write = func(expr Expr) {
+		switch e := resolve(expr).(type) {
+		case Number:
+			sb.WriteString(strconv.FormatInt(int64(e), 10))
+		case Symbol:
+			sb.WriteString(string(e))
+		case *Ap:
+			sb.WriteString("ap ")
+			write(e.Left)
+			sb.WriteByte(' ')
+			write(e.Right)
+		}
+	}
+	write(expr)
+	return sb.String()
+}
+
+// lazyValue views an expression as a value, evaluating only the parts that
+// are inspected. Evaluation errors, such as a timeout, are returned as is;
+// values of the wrong shape give a *ValueError.
+type lazyValue struct {
+	ev   *evaluator
+	expr Expr
+}
+
+// newEvaluator returns an evaluator for symbols that stops when ctx is done,
+// and interns, limits and counts its steps when ctx carries an interner, a
+// budget and a step meter.
+func newEvaluator(ctx context.Context, symbols map[Symbol]Expr) *evaluator {
+	ev := &evaluator{symbols: symbols, ctx: ctx, intern: internerFrom(ctx), budget: budgetFrom(ctx), meter: meterFrom(ctx)}
+	if ev.budget != nil {
+		ev.allowance = ev.budget.remaining()
+	}
+	return ev
+}
+
+// view returns a lazy view of expr.
+func (ev *evaluator) view(expr Expr) lazyValue {
+	if ev.intern != nil {
+		expr = ev.intern.intern(expr)
+	}
+	return lazyValue{ev: ev, expr: expr}
+}
+
+// force evaluates the viewed expression to weak head normal form.
+func (v lazyValue) force() (Expr, error) {
+	return v.ev.evalSafe(v.expr)
+}
+
+func (v lazyValue) AsInt() (int64, error) {
+	expr, err := v.force()
+	if err != nil {
+		return 0, err
+	}
+	if n, ok := expr.(Number); ok {
+		return int64(n), nil
+	}
+	return 0, &ValueError{"a number", toValue(expr)}
+}
+
+// AsPair returns views of the head and tail of a cons cell.
+func (v lazyValue) AsPair() (head, tail lazyValue, err error) {
+	expr, err := v.force()
+	if err != nil {
+		return lazyValue{}, lazyValue{}, err
+	}
+	h, t, ok := consCell(expr)
+	if !ok {
+		return lazyValue{}, lazyValue{}, &ValueError{"a pair", toValue(expr)}
+	}
+	return lazyValue{v.ev, h}, lazyValue{v.ev, t}, nil
+}
+
+// AsList returns views of the elements of a list, evaluating its spine
+// but not the elements.
+func (v lazyValue) AsList() ([]lazyValue, error) {
+	var items []lazyValue
+	for {
+		expr, err := v.force()
+		if err != nil {
+			return nil, err
+		}
+		if expr == Symbol("nil") {
+			return items, nil
+		}
+		h, t, ok := consCell(expr)
+		if !ok {
+			return nil, &ValueError{"a list", toValue(expr)}
+		}
+		items = append(items, lazyValue{v.ev, h})
+		v = lazyValue{v.ev, t}
+	}
+}
+
+// Expr returns the viewed expression, with whatever evaluation it has had
+// cached in it.
+func (v lazyValue) Expr() Expr {
+	return v.expr
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintEvaluated(t *testing.T) {
+	symbols := map[Symbol]Expr{"inc": &Ap{Left: Symbol("add"), Right: Number(1)}}
+	expr, err := parseLine("ap ap cons ap inc 1 nil")
+	require.NoError(t, err)
+	head := expr.(*Ap).Left.(*Ap).Right
+	eval(head, symbols)
+
+	assert.Equal(t, "ap ap cons ap inc 1 nil", printExpr(expr))
+	assert.Equal(t, "ap ap cons 2 nil", printEvaluated(expr))
+	assert.Equal(t, List{Int(2)}, toValue(expr))
+}
+
+func TestLazyValue(t *testing.T) {
+	symbols := map[Symbol]Expr{}
+	expr, err := parseLine("ap ap cons 1 ap ap cons ap add 1 nil")
+	require.NoError(t, err)
+	v := newEvaluator(context.Background(), symbols).view(expr)
+
+	items, err := v.AsList()
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	n, err := items[0].AsInt()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// The closure can be passed on even though it is not data.
+	_, err = items[1].AsInt()
+	assert.EqualError(t, err, "expected a number, got ap add 1")
+	assert.Equal(t, "ap add 1", printEvaluated(items[1].Expr()))
+
+	_, _, err = items[0].AsPair()
+	var valueErr *ValueError
+	assert.ErrorAs(t, err, &valueErr)
+}
+
+func TestInteractKeepsClosuresInState(t *testing.T) {
+	// galaxy ignores its arguments and returns a state holding a partial
+	// application, which doesn't reduce further and which a full
+	// conversion to values can't represent.
+	symbols, err := parseProgramText("galaxy = ap t ap t ap ap cons 0 ap ap cons ap add 1 ap ap cons nil nil")
+	require.NoError(t, err)
+
+	resp, err := interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ap add 1", resp.NewState)
+	assert.IsType(t, Unevaluated{}, resp.NewStateValue.Value)
+	assert.Equal(t, [][]PointPair{}, resp.Images)
+
+	symbols, err = parseProgramText("galaxy = ap t ap t ap ap cons 0 nil")
+	require.NoError(t, err)
+	_, err = interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	assert.Equal(t, errBadInteractResult, err)
+}
+
+func TestInteractNormalizesState(t *testing.T) {
+	// The state is a partial application whose argument galaxy never
+	// needed, so it was left unevaluated.
+	symbols, err := parseProgramText("galaxy = ap t ap t ap ap cons 0 ap ap cons ap add ap ap add 1 2 ap ap cons nil nil")
+	require.NoError(t, err)
+	resp, err := interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ap add 3", resp.NewState)
+	assert.Equal(t, Unevaluated{Expr: &Ap{Left: Symbol("add"), Right: Number(3)}}, resp.NewStateValue.Value)
+
+	// Only what doesn't reduce within the budget stays as it is.
+	symbols, err = parseProgramText("galaxy = ap t ap t ap ap cons 0 ap ap cons ap add ap ap ap s i i ap ap s i i ap ap cons nil nil")
+	require.NoError(t, err)
+	resp, err = interact(withBudget(context.Background(), 1000), symbols, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp.NewState, "ap add ap "), resp.NewState)
+}
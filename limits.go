@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default request limits of the serve command.
+const (
+	defaultMaxBodyBytes = 1 << 20
+	defaultRate         = 10
+	defaultBurst        = 20
+	defaultEvalQueue    = 64
+	defaultQueueWait    = 5 * time.Second
+	defaultStepRate     = 2_000_000
+	defaultStepBurst    = 20_000_000
+)
+
+// maxTrackedClients bounds how many clients the limiter remembers; clients
+// whose quotas have refilled are forgotten first.
+const maxTrackedClients = 10_000
+
+// Limits configures the limiter. Zero values disable a limit.
+type Limits struct {
+	// MaxBodyBytes bounds the size of request bodies.
+	MaxBodyBytes int64
+	// Rate is how many requests per second each client may make, with
+	// bursts of up to Burst.
+	Rate  float64
+	Burst int
+	// MaxEvals bounds the evaluations that run at once. Up to MaxQueue
+	// more wait for QueueWait at most.
+	MaxEvals  int
+	MaxQueue  int
+	QueueWait time.Duration
+	// StepRate is how many reduction steps per second each client may
+	// use, saved up to StepBurst, which also bounds each request.
+	StepRate  int64
+	StepBurst int64
+}
+
+// bucket is a token bucket that refills continuously.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill at rate per second,
+// up to capacity.
+func (b *bucket) refill(now time.Time, rate, capacity float64) {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+type clientQuota struct {
+	requests bucket
+	steps    bucket
+}
+
+// limiter protects the evaluating endpoints: it bounds request bodies,
+// rate limits each client, caps and queues concurrent evaluations, and
+// gives each request a reduction budget from its client's step quota.
+type limiter struct {
+	mu      sync.Mutex
+	limits  Limits
+	clients map[string]*clientQuota
+	evals   chan struct{}
+	waiting atomic.Int64
+	now     func() time.Time
+}
+
+var requestLimits = newLimiter(Limits{MaxBodyBytes: defaultMaxBodyBytes})
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{now: time.Now}
+	l.configure(limits)
+	return l
+}
+
+// configure replaces the limits, raising bursts below one request or one
+// second of steps. Evaluations running under the old cap are not counted
+// against the new one.
+func (l *limiter) configure(limits Limits) {
+	if limits.Rate > 0 {
+		limits.Burst = max(limits.Burst, 1)
+	}
+	if limits.StepRate > 0 {
+		limits.StepBurst = max(limits.StepBurst, limits.StepRate)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	l.clients = map[string]*clientQuota{}
+	l.evals = nil
+	if limits.MaxEvals > 0 {
+		l.evals = make(chan struct{}, limits.MaxEvals)
+	}
+}
+
+// clientIP identifies the client of a request by its remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// quota returns the quota of a client, refilled up to now. It must be
+// called with l.mu held.
+func (l *limiter) quota(client string, now time.Time) *clientQuota {
+	q, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= maxTrackedClients {
+			l.forgetIdle(now)
+		}
+		q = &clientQuota{
+			requests: bucket{tokens: float64(l.limits.Burst), last: now},
+			steps:    bucket{tokens: float64(l.limits.StepBurst), last: now},
+		}
+		l.clients[client] = q
+	}
+	q.requests.refill(now, l.limits.Rate, float64(l.limits.Burst))
+	q.steps.refill(now, float64(l.limits.StepRate), float64(l.limits.StepBurst))
+	return q
+}
+
+// forgetIdle drops the clients whose quotas are full again, which are
+// the same as new ones.
+func (l *limiter) forgetIdle(now time.Time) {
+	for client, q := range l.clients {
+		q.requests.refill(now, l.limits.Rate, float64(l.limits.Burst))
+		q.steps.refill(now, float64(l.limits.StepRate), float64(l.limits.StepBurst))
+		if q.requests.tokens >= float64(l.limits.Burst) && q.steps.tokens >= float64(l.limits.StepBurst) {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// admit takes a request from the client's rate limit and returns the
+// reduction budget of the request, 0 for none. The budget is all of the
+// client's steps, which are reserved until settle, so that requests running
+// at once can't spend them more than once. When the client is over a limit,
+// it returns why and the seconds until it may try again.
+func (l *limiter) admit(client string) (budget int64, refused string, retryAfter int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.Rate <= 0 && l.limits.StepRate <= 0 {
+		return 0, "", 0
+	}
+	q := l.quota(client, l.now())
+	if l.limits.StepRate > 0 {
+		if q.steps.tokens < 1 {
+			return 0, "Reduction quota exhausted", retrySeconds(1-q.steps.tokens, float64(l.limits.StepRate))
+		}
+		budget = int64(q.steps.tokens)
+	}
+	if l.limits.Rate > 0 {
+		if q.requests.tokens < 1 {
+			return 0, "Rate limit exceeded", retrySeconds(1-q.requests.tokens, l.limits.Rate)
+		}
+		q.requests.tokens--
+	}
+	q.steps.tokens -= float64(budget)
+	return budget, "", 0
+}
+
+func retrySeconds(missing, rate float64) int {
+	return max(1, int(math.Ceil(missing/rate)))
+}
+
+// settle gives back the steps of a request's budget it didn't use, or
+// takes the few it used beyond it.
+func (l *limiter) settle(client string, budget, steps int64) {
+	if budget == steps {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.StepRate > 0 {
+		q := l.quota(client, l.now())
+		q.steps.tokens = math.Min(float64(l.limits.StepBurst), q.steps.tokens+float64(budget-steps))
+	}
+}
+
+var errServerBusy = errors.New("server busy")
+
+// acquire waits for a free evaluation slot, queueing for up to QueueWait
+// when all are taken, and returns the function that frees it.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+	l.mu.Lock()
+	evals, limits := l.evals, l.limits
+	l.mu.Unlock()
+	if evals == nil {
+		return func() {}, nil
+	}
+	release = func() { <-evals }
+	select {
+	case evals <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if l.waiting.Add(1) > int64(limits.MaxQueue) {
+		l.waiting.Add(-1)
+		return nil, errServerBusy
+	}
+	defer l.waiting.Add(-1)
+	timer := time.NewTimer(limits.QueueWait)
+	defer timer.Stop()
+	select {
+	case evals <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, errServerBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type meterKey struct{}
+
+// withStepMeter counts the reduction steps of evaluations under ctx in
+// steps.
+func withStepMeter(ctx context.Context, steps *atomic.Int64) context.Context {
+	return context.WithValue(ctx, meterKey{}, steps)
+}
+
+func meterFrom(ctx context.Context) *atomic.Int64 {
+	steps, _ := ctx.Value(meterKey{}).(*atomic.Int64)
+	return steps
+}
+
+// LimitResponse is the body of requests refused by the limiter.
+type LimitResponse struct {
+	Error string `json:"error"`
+}
+
+func writeLimitError(w http.ResponseWriter, status int, retryAfter int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(LimitResponse{Error: msg})
+}
+
+// limit wraps an evaluating handler in the limits: requests over the
+// client's rate or step quota get 429, and requests that find all
+// evaluation slots and the queue taken, or wait too long, get 503.
+func (l *limiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		maxBody, queueWait := l.limits.MaxBodyBytes, l.limits.QueueWait
+		l.mu.Unlock()
+		if maxBody > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+
+		client := clientIP(r)
+		budget, refused, retryAfter := l.admit(client)
+		if refused != "" {
+			writeLimitError(w, http.StatusTooManyRequests, retryAfter, refused)
+			return
+		}
+
+		release, err := l.acquire(r.Context())
+		if err != nil {
+			l.settle(client, budget, 0)
+			writeLimitError(w, http.StatusServiceUnavailable, max(1, int(queueWait.Seconds())), "Server busy")
+			return
+		}
+		defer release()
+
+		var steps atomic.Int64
+		ctx := withStepMeter(withBudget(r.Context(), budget), &steps)
+		next(w, r.WithContext(ctx))
+		l.settle(client, budget, steps.Load())
+	}
+}
+
+// bodyError reports a request body that couldn't be decoded: 413 when it
+// was over the size limit and 400 otherwise.
+func bodyError(err error) (int, string) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge, "Request body too large"
+	}
+	return http.StatusBadRequest, "Invalid JSON"
+}
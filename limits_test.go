@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock returns a clock for a limiter and a function that advances it.
+func fakeClock(l *limiter) func(time.Duration) {
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := newLimiter(Limits{Rate: 1, Burst: 2})
+	advance := fakeClock(l)
+	for i := 0; i < 2; i++ {
+		_, refused, _ := l.admit("a")
+		assert.Empty(t, refused)
+	}
+	_, refused, retryAfter := l.admit("a")
+	assert.Equal(t, "Rate limit exceeded", refused)
+	assert.Equal(t, 1, retryAfter)
+
+	// Other clients have their own buckets.
+	_, refused, _ = l.admit("b")
+	assert.Empty(t, refused)
+
+	advance(time.Second)
+	_, refused, _ = l.admit("a")
+	assert.Empty(t, refused)
+}
+
+func TestLimiterStepQuota(t *testing.T) {
+	l := newLimiter(Limits{StepRate: 100, StepBurst: 1000})
+	advance := fakeClock(l)
+	budget, refused, _ := l.admit("a")
+	assert.Empty(t, refused)
+	assert.Equal(t, int64(1000), budget)
+
+	l.settle("a", budget, 1500)
+	_, refused, retryAfter := l.admit("a")
+	assert.Equal(t, "Reduction quota exhausted", refused)
+	assert.Equal(t, 6, retryAfter)
+
+	advance(10 * time.Second)
+	budget, refused, _ = l.admit("a")
+	assert.Empty(t, refused)
+	assert.Equal(t, int64(500), budget)
+
+	// The budget is reserved while the request runs, so one running at
+	// the same time gets none, and the unused steps are given back.
+	_, refused, _ = l.admit("a")
+	assert.Equal(t, "Reduction quota exhausted", refused)
+	l.settle("a", budget, 200)
+	budget, refused, _ = l.admit("a")
+	assert.Empty(t, refused)
+	assert.Equal(t, int64(300), budget)
+	l.settle("a", budget, 0)
+	advance(time.Hour)
+	budget, _, _ = l.admit("a")
+	assert.Equal(t, int64(1000), budget)
+
+	// A burst below one second of steps is raised.
+	assert.Equal(t, int64(100), newLimiter(Limits{StepRate: 100}).limits.StepBurst)
+}
+
+func TestLimiterForgetsIdleClients(t *testing.T) {
+	l := newLimiter(Limits{Rate: 1, Burst: 1})
+	advance := fakeClock(l)
+	for i := 0; i < maxTrackedClients; i++ {
+		l.admit(strings.Repeat("x", i))
+	}
+	advance(time.Second)
+	l.admit("new")
+	assert.Len(t, l.clients, 1)
+}
+
+func TestLimiterQueue(t *testing.T) {
+	l := newLimiter(Limits{MaxEvals: 1, MaxQueue: 1, QueueWait: time.Hour})
+	ctx := context.Background()
+	release, err := l.acquire(ctx)
+	require.NoError(t, err)
+
+	queued := make(chan error)
+	go func() {
+		release, err := l.acquire(ctx)
+		if err == nil {
+			release()
+		}
+		queued <- err
+	}()
+	require.Eventually(t, func() bool { return l.waiting.Load() == 1 }, time.Second, time.Millisecond)
+
+	// The queue is full.
+	_, err = l.acquire(ctx)
+	assert.ErrorIs(t, err, errServerBusy)
+
+	release()
+	assert.NoError(t, <-queued)
+
+	l = newLimiter(Limits{MaxEvals: 1, MaxQueue: 1, QueueWait: time.Millisecond})
+	_, err = l.acquire(ctx)
+	require.NoError(t, err)
+	_, err = l.acquire(ctx)
+	assert.ErrorIs(t, err, errServerBusy)
+}
+
+func withLimits(t *testing.T, limits Limits) {
+	previous := requestLimits.limits
+	requestLimits.configure(limits)
+	t.Cleanup(func() { requestLimits.configure(previous) })
+}
+
+func TestLimitedEndpoints(t *testing.T) {
+	require.NoError(t, loadProgram("galaxy.txt"))
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	post := func(path, body string) (*http.Response, map[string]interface{}) {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+		return resp, decoded
+	}
+
+	withLimits(t, Limits{MaxBodyBytes: 64})
+	resp, body := post("/eval", `{"expression": "`+strings.Repeat("1 ", 64)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Equal(t, "Request body too large", body["error"])
+
+	withLimits(t, Limits{Rate: 1, Burst: 1})
+	resp, _ = post("/interact", `{"state": "nil"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = post("/interact", `{"state": "nil"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "Rate limit exceeded", body["error"])
+
+	// The quota bounds each request, whatever budget it asks for, and the
+	// steps it used are taken from the quota.
+	withLimits(t, Limits{StepRate: 1, StepBurst: 5})
+	resp, body = post("/eval", `{"expression": "ap ap galaxy nil ap ap cons 0 0", "budget": 1000000}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "reduction budget exhausted", body["error"])
+
+	withLimits(t, Limits{StepRate: 1, StepBurst: 100_000})
+	omega := "ap ap ap s i i ap ap s i i"
+	resp, _ = post("/eval", `{"expression": "`+omega+`"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	resp, body = post("/eval", `{"expression": "1"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "Reduction quota exhausted", body["error"])
+}
+
+func TestWithBudgetKeepsSmallerBudget(t *testing.T) {
+	ctx := withBudget(context.Background(), 10)
+	assert.Equal(t, int64(10), budgetFrom(withBudget(ctx, 100)).remaining())
+	assert.Equal(t, int64(5), budgetFrom(withBudget(ctx, 5)).remaining())
+	assert.Equal(t, int64(10), budgetFrom(withBudget(ctx, 0)).remaining())
+	assert.Nil(t, budgetFrom(context.Background()))
+}
+
+func TestBudgetIsShared(t *testing.T) {
+	ctx := withBudget(context.Background(), 10)
+	budget := budgetFrom(ctx)
+	budget.spend(4)
+	assert.Equal(t, int64(6), budget.remaining())
+
+	// A smaller budget carved out of it spends from both.
+	inner := budgetFrom(withBudget(ctx, 3))
+	inner.spend(2)
+	assert.Equal(t, int64(1), inner.remaining())
+	assert.Equal(t, int64(4), budget.remaining())
+	// What is left of the outer budget bounds the inner one.
+	budget.spend(4)
+	assert.Equal(t, int64(0), inner.remaining())
+
+	// Evaluations under the same budget share it.
+	symbols := map[Symbol]Expr{}
+	ctx = withBudget(context.Background(), 25)
+	expr, err := parseLine("ap ap add 1 ap ap add 2 ap ap add 3 4")
+	require.NoError(t, err)
+	_, err = evalContext(ctx, expr, symbols)
+	require.NoError(t, err)
+	steps := 25 - budgetFrom(ctx).remaining()
+	assert.Positive(t, steps)
+	for range 25/steps - 1 {
+		expr, _ = parseLine("ap ap add 1 ap ap add 2 ap ap add 3 4")
+		_, err = evalContext(ctx, expr, symbols)
+		require.NoError(t, err)
+	}
+	expr, _ = parseLine("ap ap add 1 ap ap add 2 ap ap add 3 4")
+	_, err = evalContext(ctx, expr, symbols)
+	assert.Equal(t, errBudgetExhausted, err)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logLevel is the level of the default logger, which setupLogging
+// installs.
+var logLevel = new(slog.LevelVar)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// setupLogging makes the default logger write to w at level, as text or as
+// one JSON object per line, and tag records with their request ID.
+func setupLogging(w io.Writer, level, format string) error {
+	l, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	logLevel.Set(l)
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+	return nil
+}
+
+// requestIDHandler adds the ID of the request a record was logged for, if
+// any, to the record.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// maxLoggedExpr is how much of an expression is logged; states can be
+// megabytes long.
+const maxLoggedExpr = 256
+
+// loggedExpr logs an expression, truncated to maxLoggedExpr bytes. It is
+// only printed when the record is written.
+type loggedExpr struct {
+	Expr
+}
+
+func (e loggedExpr) LogValue() slog.Value {
+	if e.Expr == nil {
+		return slog.StringValue("")
+	}
+	return slog.StringValue(truncate(printExpr(e.Expr), maxLoggedExpr))
+}
+
+// requestIDHeader carries the ID of a request, which clients may choose and
+// responses echo.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the IDs clients may choose.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random ID for a request.
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether a client-chosen ID is short and printable
+// ASCII, so that it is safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder remembers the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withRequestID gives every request an ID, taken from its X-Request-ID
+// header when that is valid, puts it in the request context for the
+// logger, echoes it in the response and logs the request once it is
+// served.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.InfoContext(ctx, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"client", clientIP(r))
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs sends the default logger's JSON records to the returned
+// buffer for the rest of the test.
+func captureLogs(t *testing.T, level string) *bytes.Buffer {
+	previous, previousLevel := slog.Default(), logLevel.Level()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		logLevel.Set(previousLevel)
+	})
+	var buf bytes.Buffer
+	require.NoError(t, setupLogging(&buf, level, "json"))
+	return &buf
+}
+
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), scanner.Text())
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestSetupLoggingRejectsUnknownSettings(t *testing.T) {
+	captureLogs(t, "info")
+	assert.EqualError(t, setupLogging(&bytes.Buffer{}, "loud", "text"), `invalid log level "loud"`)
+	assert.EqualError(t, setupLogging(&bytes.Buffer{}, "info", "xml"), `invalid log format "xml"`)
+}
+
+func TestRequestIDs(t *testing.T) {
+	logs := captureLogs(t, "info")
+
+	rr := serve(http.MethodGet, "/programs", "")
+	generated := rr.Header().Get(requestIDHeader)
+	assert.Len(t, generated, 16)
+
+	req := httptest.NewRequest(http.MethodGet, "/programs", nil)
+	req.Header.Set(requestIDHeader, "trace-42")
+	rr = httptest.NewRecorder()
+	newMux().ServeHTTP(rr, req)
+	assert.Equal(t, "trace-42", rr.Header().Get(requestIDHeader))
+
+	req.Header.Set(requestIDHeader, "bad\x01id")
+	rr = httptest.NewRecorder()
+	newMux().ServeHTTP(rr, req)
+	assert.NotEqual(t, "bad\x01id", rr.Header().Get(requestIDHeader))
+
+	records := logRecords(t, logs)
+	require.Len(t, records, 3)
+	assert.Equal(t, "request", records[0]["msg"])
+	assert.Equal(t, generated, records[0]["request_id"])
+	assert.Equal(t, "/programs", records[0]["path"])
+	assert.Equal(t, float64(http.StatusOK), records[0]["status"])
+	assert.Equal(t, "trace-42", records[1]["request_id"])
+}
+
+func TestDebugLogsTruncateExpressions(t *testing.T) {
+	logs := captureLogs(t, "debug")
+	expression := "ap inc " + strings.Repeat("ap inc ", 100) + "0"
+	req := httptest.NewRequest(http.MethodPost, "/eval", strings.NewReader(`{"expression": "`+expression+`"}`))
+	req.Header.Set(requestIDHeader, "eval-1")
+	rr := httptest.NewRecorder()
+	newMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var eval map[string]any
+	for _, record := range logRecords(t, logs) {
+		assert.Equal(t, "eval-1", record["request_id"])
+		if record["msg"] == "eval" {
+			eval = record
+		}
+	}
+	require.NotNil(t, eval)
+	logged := eval["expression"].(string)
+	assert.Equal(t, maxLoggedExpr+len("..."), len(logged))
+	assert.True(t, strings.HasPrefix(expression, strings.TrimSuffix(logged, "...")))
+}
+
+func TestInfoLevelSkipsDebugRecords(t *testing.T) {
+	logs := captureLogs(t, "info")
+	rr := serve(http.MethodPost, "/interact", `{"state": "nil"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	for _, record := range logRecords(t, logs) {
+		assert.Equal(t, "INFO", record["level"])
+	}
+}
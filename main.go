@@ -1,50 +1,102 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 )
 
-var galaxy map[Symbol]Expr
+// evalTimeout bounds each evaluation performed on behalf of an HTTP request.
+// Zero means no limit.
+var evalTimeout time.Duration
 
-func init() {
-	program, err := parseProgram("./galaxy.txt")
-	if err != nil {
-		log.Fatalf("failed to parse program: %v", err)
+// loadProgram parses the program at path and registers it as the default
+// program used by the handlers and commands.
+func loadProgram(path string) error {
+	if _, err := programs.load(defaultProgram, path); err != nil {
+		return fmt.Errorf("failed to parse program: %w", err)
+	}
+	return nil
+}
+
+// withEvalTimeout derives the context an evaluation should run under.
+func withEvalTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if evalTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, evalTimeout)
+}
+
+// evalErrorStatus maps an evaluation error to an HTTP status code. Sends
+// to the aliens that fail on their own, rather than by timing out, are the
+// upstream's fault.
+func evalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errEvalTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errBudgetExhausted):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errShuttingDown):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errSendFailed):
+		return http.StatusBadGateway
 	}
-	galaxy = program
+	return http.StatusInternalServerError
 }
 
+// EvalRequest selects how far the result is normalized with Normalize,
+// one of "whnf" (the default), "nf" or "depth" (the top Depth levels), and
+// can limit the reduction steps with Budget.
 type EvalRequest struct {
 	Expression string `json:"expression"`
+	Program    string `json:"program,omitempty"`
+	Normalize  string `json:"normalize,omitempty"`
+	Depth      int    `json:"depth,omitempty"`
+	Budget     int64  `json:"budget,omitempty"`
 }
 
+// EvalResponse carries the result in the tagged JSON encoding of values.
+// Truncated reports that normalization stopped short of the requested
+// form because it ran out of budget.
 type EvalResponse struct {
-	Result interface{} `json:"result"`
-	Error  string      `json:"error,omitempty"`
+	Result    *TaggedValue `json:"result,omitempty"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Error     string       `json:"error,omitempty"`
 }
 
+// InteractRequest takes the state either printed, in State, or in the
+// tagged JSON encoding, in StateValue. Send opts /interact in to the full
+// interaction protocol, which the stream always runs.
 type InteractRequest struct {
-	State string `json:"state"`
-	Point struct {
+	State      string       `json:"state"`
+	StateValue *TaggedValue `json:"stateValue,omitempty"`
+	Program    string       `json:"program,omitempty"`
+	Send       bool         `json:"send,omitempty"`
+	Point      struct {
 		X int `json:"x"`
 		Y int `json:"y"`
 	} `json:"point"`
 }
 
 type InteractResponse struct {
-	Flag     int64         `json:"flag"`
-	NewState string        `json:"newstate"`
-	Images   [][]PointPair `json:"images"`
-	Error    string        `json:"error,omitempty"`
+	Flag          int64         `json:"flag"`
+	NewState      string        `json:"newstate"`
+	NewStateValue *TaggedValue  `json:"newstateValue,omitempty"`
+	Images        [][]PointPair `json:"images"`
+	Data          string        `json:"data,omitempty"`
+	Hash          string        `json:"hash,omitempty"`
+	Error         string        `json:"error,omitempty"`
 }
 
 type PointPair struct {
-	X int64 `json:"x"`
-	Y int64 `json:"y"`
+	X int64 `json:"x" galaxy:"0"`
+	Y int64 `json:"y" galaxy:"1"`
 }
 
 func evalHandler(w http.ResponseWriter, r *http.Request) {
@@ -58,8 +110,9 @@ func evalHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req EvalRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(EvalResponse{Error: "Invalid JSON"})
+		status, msg := bodyError(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(EvalResponse{Error: msg})
 		return
 	}
 
@@ -78,22 +131,32 @@ func evalHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	depth, err := parseNormalization(req.Normalize, req.Depth)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(EvalResponse{Error: err.Error()})
+		return
+	}
+
+	prog, ok := programs.get(req.Program)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(EvalResponse{Error: "Unknown program"})
+		return
+	}
+
 	// Evaluate the expression
-	result := eval(expr, galaxy)
-
-	// Try to convert to a value, handle panics for unsupported expressions
-	var value interface{}
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				// If toValue panics, return the string representation
-				value = printExpr(result)
-			}
-		}()
-		value = toValue(result)
-	}()
-
-	json.NewEncoder(w).Encode(EvalResponse{Result: value})
+	slog.DebugContext(r.Context(), "eval", "program", prog.name, "expression", loggedExpr{expr})
+	ctx, cancel := withEvalTimeout(withBudget(prog.context(r.Context()), req.Budget))
+	defer cancel()
+	result, truncated, err := normalize(ctx, expr, prog.symbols, depth)
+	if err != nil {
+		w.WriteHeader(evalErrorStatus(err))
+		json.NewEncoder(w).Encode(EvalResponse{Error: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(EvalResponse{Result: &TaggedValue{toValue(result)}, Truncated: truncated})
 }
 
 func interactHandler(w http.ResponseWriter, r *http.Request) {
@@ -107,123 +170,61 @@ func interactHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req InteractRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(InteractResponse{Error: "Invalid JSON"})
+		status, msg := bodyError(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(InteractResponse{Error: msg})
 		return
 	}
 
 	// Parse the state expression
-	if strings.TrimSpace(req.State) == "" {
+	stateExpr, errMsg := req.stateExpr()
+	if stateExpr == nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(InteractResponse{Error: "Invalid state"})
+		json.NewEncoder(w).Encode(InteractResponse{Error: errMsg})
 		return
 	}
 
-	stateTokens := strings.Split(strings.TrimSpace(req.State), " ")
-	stateExpr, remaining := parseExpr(stateTokens)
-	if stateExpr == nil || len(remaining) > 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(InteractResponse{Error: "Invalid state expression"})
+	prog, ok := programs.get(req.Program)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(InteractResponse{Error: "Unknown program"})
 		return
 	}
 
-	// Create point expression: ap ap cons x y
-	pointExpr := &Ap{
-		Left: &Ap{
-			Left:  Symbol("cons"),
-			Right: Number(req.Point.X),
-		},
-		Right: Number(req.Point.Y),
-	}
-
-	// Call galaxy function with state and point: ap ap galaxy state point
-	// Check if galaxy function exists
-	if _, exists := galaxy[Symbol("galaxy")]; !exists {
-		// If galaxy function doesn't exist, return a default response
-		json.NewEncoder(w).Encode(InteractResponse{
-			NewState: req.State,       // Return the same state
-			Images:   [][]PointPair{}, // Empty images
-		})
+	ctx, cancel := withEvalTimeout(prog.context(r.Context()))
+	defer cancel()
+	resp, err := cachedInteract(ctx, prog, stateExpr, int64(req.Point.X), int64(req.Point.Y), req.Send, func(InteractEvent) {})
+	if err != nil {
+		w.WriteHeader(evalErrorStatus(err))
+		json.NewEncoder(w).Encode(InteractResponse{Error: err.Error()})
 		return
 	}
+	resp.Hash = states.put(prog, resp).Hash
+	json.NewEncoder(w).Encode(resp)
+}
 
-	fmt.Printf("Debug - interaction with state: %s\n", printExpr(stateExpr))
-	interactExpr := &Ap{
-		Left: &Ap{
-			Left:  Symbol("galaxy"),
-			Right: stateExpr,
-		},
-		Right: pointExpr,
-	}
-
-	// Evaluate the interaction with proper error handling
-	var result Expr
-	var evalError error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				evalError = fmt.Errorf("evaluation failed: %v", r)
-			}
-		}()
-		result = eval(interactExpr, galaxy)
-	}()
-
-	if evalError != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(InteractResponse{Error: evalError.Error()})
-		return
+// stateExpr returns the state of the request, preferring StateValue when it
+// is set.
+func (req InteractRequest) stateExpr() (Expr, string) {
+	if req.StateValue != nil {
+		return req.StateValue.ToExpr(), ""
 	}
+	return parseState(req.State)
+}
 
-	fmt.Printf("Debug - interaction result: %s %v\n", printExpr(result), toValue(result))
-
-	// Try to convert the result to the expected format
-	var flag int64
-	var newState string
-	var images [][]PointPair
-	var responseSent bool
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Printf("Debug - interaction result conversion failed: %v\n", r)
-				// If parsing fails, return error
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(InteractResponse{Error: "Failed to process interaction result"})
-				responseSent = true
-			}
-		}()
-
-		// Parse the result which should be a galaxy response: [flag, newState, images]
-		resultValue := toValue(result)
-		resultSlice := resultValue.([]interface{})
-		flag = resultSlice[0].(int64)
-		newState = printExpr(valueToExpr(resultSlice[1]))
-		images = parseImages(resultSlice[2])
-	}()
-
-	if !responseSent {
-		json.NewEncoder(w).Encode(InteractResponse{
-			Flag:     flag,
-			NewState: newState,
-			Images:   images,
-		})
+// parseState parses the state of an interact request, returning a nil
+// expression and the error to report when it is invalid.
+func parseState(state string) (Expr, string) {
+	if strings.TrimSpace(state) == "" {
+		return nil, "Invalid state"
 	}
-}
 
-// Helper function to parse images from the result
-func parseImages(imagesValue interface{}) [][]PointPair {
-	var images [][]PointPair
-	for _, imageValue := range imagesValue.([]interface{}) {
-		images = append(images, []PointPair{})
-		for _, pointValue := range imageValue.([]interface{}) {
-			images[len(images)-1] = append(images[len(images)-1], PointPair{
-				X: pointValue.(Pair).Left.(int64),
-				Y: pointValue.(Pair).Right.(int64),
-			})
-		}
+	stateTokens := strings.Split(strings.TrimSpace(state), " ")
+	stateExpr, remaining := parseExpr(stateTokens)
+	if stateExpr == nil || len(remaining) > 0 {
+		return nil, "Invalid state expression"
 	}
-	return images
+	return stateExpr, ""
 }
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
@@ -232,7 +233,17 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	html := `<!DOCTYPE html>
+	writePage(w, nil)
+}
+
+// writePage serves the web UI, opened at initial when it is not nil.
+func writePage(w http.ResponseWriter, initial *StoredState) {
+	initialJSON, _ := json.Marshal(initial)
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(strings.Replace(pageHTML, "/*INITIAL_STATE*/null", string(initialJSON), 1)))
+}
+
+const pageHTML = `<!DOCTYPE html>
 <html>
 <head>
     <title>Galaxy Interpreter</title>
@@ -250,6 +261,7 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
         canvas { border: 2px solid #333; background: white; }
         .controls { margin: 10px 0; }
         .state-display { background: #f8f8f8; padding: 10px; border-radius: 5px; font-family: monospace; white-space: pre-wrap; }
+        .symbol-list a { margin-right: 8px; font-family: monospace; cursor: pointer; }
     </style>
 </head>
 <body>
@@ -279,17 +291,40 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
             </div>
 
             <div class="canvas-container">
-                <canvas id="galaxyCanvas" width="400" height="400"></canvas>
-                <p><em>Click on the canvas to interact with those coordinates</em></p>
+                <div class="controls">
+                    <label><input type="checkbox" id="lockView"> Lock view between frames</label>
+                    <label><input type="checkbox" id="showAxes"> Show origin and axes</label>
+                    <button onclick="resetView()">Fit to images</button>
+                    Cursor: <span id="cursorCoords">-</span>
+                </div>
+                <canvas id="galaxyCanvas" width="600" height="600"></canvas>
+                <p><em>Click on the canvas to interact with those coordinates. Scroll to zoom, drag to pan.</em></p>
             </div>
 
             <div>
                 <h3>Current State:</h3>
                 <div id="stateDisplay" class="state-display">nil</div>
+                <p>Permalink: <a id="permalink" href="#" style="display: none;"></a></p>
             </div>
             
             <div id="interactResult" class="result" style="display: none;"></div>
         </div>
+
+        <div class="section">
+            <h2>Symbol Browser</h2>
+            <p>Browse the definitions of a loaded program and how they depend on each other:</p>
+
+            <div class="controls">
+                <label>Program: <select id="browseProgram" onchange="selectProgram()"></select></label>
+                <label>Symbol: <input type="text" id="browseSymbol" value="galaxy"></label>
+                <button onclick="browseSymbol(document.getElementById('browseSymbol').value.trim())">Show</button>
+                Export graph: <a id="graphJSON" href="/programs/galaxy/graph">JSON</a>
+                <a id="graphDot" href="/programs/galaxy/graph?format=dot">Graphviz</a>
+            </div>
+
+            <div id="entryPoints" class="symbol-list"></div>
+            <div id="symbolResult" class="result" style="display: none;"></div>
+        </div>
     </div>
 
     <script>
@@ -331,13 +366,25 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
                 if (data.error) {
                     showEvalResult('Error: ' + data.error, true);
                 } else {
-                    showEvalResult('Result: ' + JSON.stringify(data.result, null, 2), false);
+                    showEvalResult('Result: ' + formatValue(data.result), false);
                 }
             } catch (error) {
                 showEvalResult('Network error: ' + error.message, true);
             }
         }
 
+        // formatValue prints a value in the tagged JSON encoding the way the
+        // REPL does: [a b] for lists, {a b} for pairs.
+        function formatValue(v) {
+            switch (v.type) {
+                case 'int': return String(v.value);
+                case 'nil': return '[]';
+                case 'list': return '[' + v.items.map(formatValue).join(' ') + ']';
+                case 'pair': return '{' + formatValue(v.head) + ' ' + formatValue(v.tail) + '}';
+                default: return v.expr;
+            }
+        }
+
         function showEvalResult(message, isError) {
             const resultDiv = document.getElementById('evalResult');
             resultDiv.textContent = message;
@@ -354,9 +401,65 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
             await performInteraction(state, x, y);
         }
 
-        async function performInteraction(state, x, y) {
-            const resultDiv = document.getElementById('interactResult');
-            
+        let activeStream = null;
+
+        // performInteraction streams the interaction's progress from
+        // /interact/stream, falling back to a single /interact request.
+        function performInteraction(state, x, y) {
+            if (!window.EventSource) {
+                return performInteractionOnce(state, x, y);
+            }
+            if (activeStream) {
+                activeStream.close();
+            }
+
+            const params = new URLSearchParams({ state: state, x: x, y: y });
+            const source = new EventSource('/interact/stream?' + params.toString());
+            activeStream = source;
+            let received = false;
+            const partialImages = [];
+
+            function on(type, handler) {
+                source.addEventListener(type, e => {
+                    received = true;
+                    handler(JSON.parse(e.data));
+                });
+            }
+            function finish() {
+                source.close();
+                activeStream = null;
+            }
+
+            on('started', ev => showInteractResult('Evaluating galaxy' + (ev.round > 0 ? ' (round ' + (ev.round + 1) + ')' : '') + '...', false));
+            on('flag', ev => showInteractResult('Galaxy returned flag ' + (ev.flag || 0), false));
+            on('send', ev => showInteractResult('Sending data to the aliens...', false));
+            on('received', ev => showInteractResult('Aliens responded, continuing...', false));
+            on('image', ev => {
+                partialImages[ev.layer || 0] = ev.image || [];
+                renderImages(partialImages);
+            });
+            on('done', ev => {
+                finish();
+                applyInteractResult(ev.result);
+            });
+            on('failed', ev => {
+                finish();
+                showInteractResult('Error: ' + ev.error, true);
+            });
+            source.onerror = () => {
+                if (activeStream !== source) return;
+                finish();
+                if (!received) {
+                    // The request was rejected before streaming started;
+                    // repeat it without streaming to get the error message.
+                    performInteractionOnce(state, x, y);
+                } else {
+                    showInteractResult('Network error: stream interrupted', true);
+                }
+            };
+        }
+
+        async function performInteractionOnce(state, x, y) {
             try {
                 const response = await fetch('/interact', {
                     method: 'POST',
@@ -365,7 +468,8 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
                     },
                     body: JSON.stringify({ 
                         state: state,
-                        point: { x: x, y: y }
+                        point: { x: x, y: y },
+                        send: true
                     })
                 });
 
@@ -374,19 +478,37 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
                 if (data.error) {
                     showInteractResult('Error: ' + data.error, true);
                 } else {
-                    currentState = data.newstate;
-                    document.getElementById('state').value = currentState;
-                    document.getElementById('stateDisplay').textContent = currentState;
-                    
-                    renderImages(data.images);
-                    
-                    showInteractResult('Interaction successful. Images: ' + data.images.length + ' layers', false);
+                    applyInteractResult(data);
                 }
             } catch (error) {
                 showInteractResult('Network error: ' + error.message, true);
             }
         }
 
+        function applyInteractResult(data) {
+            const images = data.images || [];
+            currentState = data.newstate;
+            document.getElementById('state').value = currentState;
+            document.getElementById('stateDisplay').textContent = currentState;
+
+            renderImages(images);
+
+            let message = 'Interaction successful. Images: ' + images.length + ' layers';
+            if (data.data) {
+                message += '. Galaxy wants to send: ' + data.data;
+            }
+            showInteractResult(message, false);
+
+            const permalink = document.getElementById('permalink');
+            if (data.hash) {
+                permalink.href = '/s/' + data.hash;
+                permalink.textContent = location.origin + '/s/' + data.hash;
+                permalink.style.display = 'inline';
+            } else {
+                permalink.style.display = 'none';
+            }
+        }
+
         function showInteractResult(message, isError) {
             const resultDiv = document.getElementById('interactResult');
             resultDiv.textContent = message;
@@ -394,18 +516,28 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
             resultDiv.style.display = 'block';
         }
 
+        // The view maps galaxy coordinates to canvas pixels:
+        // screenX = x * canvasScale - canvasOffsetX, and likewise for y.
+        let lastImages = [];
+        let dragStart = null;
+        let dragged = false;
+
         function renderImages(images) {
-            if (!images || images.length === 0) {
-                clearCanvas();
-                return;
+            lastImages = images || [];
+            if (!document.getElementById('lockView').checked) {
+                fitView(lastImages);
             }
+            drawImages();
+        }
 
+        // fitView scales and centers the view on the bounding box of images.
+        function fitView(images) {
             // Calculate bounding box for all points
             let minX = Infinity, maxX = -Infinity;
             let minY = Infinity, maxY = -Infinity;
 
             for (const image of images) {
-			    if (image == null) continue;
+                if (image == null) continue;
                 for (const point of image) {
                     minX = Math.min(minX, point.x);
                     maxX = Math.max(maxX, point.x);
@@ -413,6 +545,9 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
                     maxY = Math.max(maxY, point.y);
                 }
             }
+            if (minX === Infinity) {
+                minX = maxX = minY = maxY = 0;
+            }
 
             // Add padding
             const padding = 20;
@@ -420,82 +555,158 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
             const height = Math.max(maxY - minY + 2 * padding, 100);
 
             // Calculate scale to fit canvas
-            const maxCanvasSize = 400;
-            canvasScale = Math.min(maxCanvasSize / width, maxCanvasSize / height);
-            
-            // Update canvas size
-            canvas.width = Math.ceil(width * canvasScale);
-            canvas.height = Math.ceil(height * canvasScale);
-            
+            canvasScale = Math.min(canvas.width / width, canvas.height / height);
+
             // Calculate offset to center the content
-            canvasOffsetX = (minX - padding) * canvasScale;
-            canvasOffsetY = (minY - padding) * canvasScale;
+            canvasOffsetX = ((minX + maxX) / 2) * canvasScale - canvas.width / 2;
+            canvasOffsetY = ((minY + maxY) / 2) * canvasScale - canvas.height / 2;
+        }
 
-            // Clear canvas
+        function drawImages() {
             clearCanvas();
 
-            // Render each image layer with different colors
-            images.forEach((image, layerIndex) => {
-                if (!image) return; // Skip null/undefined images
-                
-                const color = colors[layerIndex % colors.length];
-                ctx.fillStyle = color;
-                ctx.strokeStyle = color;
-                
-                // Draw points
+            // Render each image layer with different colors, last layer
+            // first so the first layer ends up on top
+            const size = Math.max(canvasScale, 2);
+            for (let layerIndex = lastImages.length - 1; layerIndex >= 0; layerIndex--) {
+                const image = lastImages[layerIndex];
+                if (!image) continue; // Skip null/undefined images
+
+                ctx.fillStyle = colors[layerIndex % colors.length];
+
+                // Draw each point as a cell centered on its coordinates
                 image.forEach(point => {
                     const screenX = point.x * canvasScale - canvasOffsetX;
                     const screenY = point.y * canvasScale - canvasOffsetY;
-                    
-                    // Draw a small circle for each point
-                    ctx.beginPath();
-                    ctx.arc(screenX, screenY, 2, 0, 2 * Math.PI);
-                    ctx.fill();
+                    ctx.fillRect(screenX - size / 2, screenY - size / 2, size, size);
                 });
-            });
+            }
         }
 
         function clearCanvas() {
             ctx.clearRect(0, 0, canvas.width, canvas.height);
-            
-            // Draw grid for reference
+
+            // Draw a grid for reference, at a power-of-two spacing that
+            // keeps lines at least 8 pixels apart
             ctx.strokeStyle = '#f0f0f0';
             ctx.lineWidth = 1;
-            
-            const gridSize = 20 * canvasScale;
-            if (gridSize > 5) {
-                for (let x = 0; x < canvas.width; x += gridSize) {
-                    ctx.beginPath();
-                    ctx.moveTo(x, 0);
-                    ctx.lineTo(x, canvas.height);
-                    ctx.stroke();
-                }
-                for (let y = 0; y < canvas.height; y += gridSize) {
-                    ctx.beginPath();
-                    ctx.moveTo(0, y);
-                    ctx.lineTo(canvas.width, y);
-                    ctx.stroke();
-                }
+
+            let step = 1;
+            while (step * canvasScale < 8) {
+                step *= 2;
+            }
+            const first = toGalaxy(0, 0);
+            const last = toGalaxy(canvas.width, canvas.height);
+            for (let x = Math.floor(first.x / step) * step; x <= last.x; x += step) {
+                drawLine(x * canvasScale - canvasOffsetX, 0, x * canvasScale - canvasOffsetX, canvas.height);
             }
+            for (let y = Math.floor(first.y / step) * step; y <= last.y; y += step) {
+                drawLine(0, y * canvasScale - canvasOffsetY, canvas.width, y * canvasScale - canvasOffsetY);
+            }
+
+            if (document.getElementById('showAxes').checked) {
+                ctx.strokeStyle = '#888';
+                drawLine(-canvasOffsetX, 0, -canvasOffsetX, canvas.height);
+                drawLine(0, -canvasOffsetY, canvas.width, -canvasOffsetY);
+                ctx.fillStyle = '#888';
+                ctx.fillText('0,0', -canvasOffsetX + 3, -canvasOffsetY - 3);
+            }
+        }
+
+        function drawLine(x1, y1, x2, y2) {
+            ctx.beginPath();
+            ctx.moveTo(x1, y1);
+            ctx.lineTo(x2, y2);
+            ctx.stroke();
+        }
+
+        // toGalaxy converts canvas pixel coordinates to galaxy coordinates.
+        function toGalaxy(screenX, screenY) {
+            return {
+                x: (screenX + canvasOffsetX) / canvasScale,
+                y: (screenY + canvasOffsetY) / canvasScale
+            };
+        }
+
+        function canvasPosition(event) {
+            const rect = canvas.getBoundingClientRect();
+            return {
+                x: (event.clientX - rect.left) * canvas.width / rect.width,
+                y: (event.clientY - rect.top) * canvas.height / rect.height
+            };
+        }
+
+        function resetView() {
+            fitView(lastImages);
+            drawImages();
         }
 
         function resetState() {
             currentState = 'nil';
             document.getElementById('state').value = 'nil';
             document.getElementById('stateDisplay').textContent = 'nil';
+            lastImages = [];
             clearCanvas();
             document.getElementById('interactResult').style.display = 'none';
         }
 
+        // Mouse wheel zooms around the cursor
+        canvas.addEventListener('wheel', function(event) {
+            event.preventDefault();
+            const pos = canvasPosition(event);
+            const anchor = toGalaxy(pos.x, pos.y);
+            const factor = event.deltaY < 0 ? 1.25 : 0.8;
+            canvasScale = Math.min(Math.max(canvasScale * factor, 0.05), 200);
+            canvasOffsetX = anchor.x * canvasScale - pos.x;
+            canvasOffsetY = anchor.y * canvasScale - pos.y;
+            drawImages();
+        }, { passive: false });
+
+        // Dragging pans the view
+        canvas.addEventListener('mousedown', function(event) {
+            dragStart = canvasPosition(event);
+            dragged = false;
+        });
+
+        window.addEventListener('mouseup', function() {
+            dragStart = null;
+        });
+
+        canvas.addEventListener('mousemove', function(event) {
+            const pos = canvasPosition(event);
+            const point = toGalaxy(pos.x, pos.y);
+            document.getElementById('cursorCoords').textContent = Math.round(point.x) + ', ' + Math.round(point.y);
+
+            if (dragStart) {
+                const dx = pos.x - dragStart.x;
+                const dy = pos.y - dragStart.y;
+                if (dragged || Math.abs(dx) > 3 || Math.abs(dy) > 3) {
+                    dragged = true;
+                    canvasOffsetX -= dx;
+                    canvasOffsetY -= dy;
+                    dragStart = pos;
+                    drawImages();
+                }
+            }
+        });
+
+        canvas.addEventListener('mouseleave', function() {
+            document.getElementById('cursorCoords').textContent = '-';
+        });
+
         // Canvas click handler
         canvas.addEventListener('click', function(event) {
-            const rect = canvas.getBoundingClientRect();
-            const clickX = event.clientX - rect.left;
-            const clickY = event.clientY - rect.top;
-            
+            if (dragged) {
+                // The click ends a pan, not an interaction
+                dragged = false;
+                return;
+            }
+            const pos = canvasPosition(event);
+
             // Convert screen coordinates to galaxy coordinates
-            const galaxyX = Math.round((clickX + canvasOffsetX) / canvasScale);
-            const galaxyY = Math.round((clickY + canvasOffsetY) / canvasScale);
+            const point = toGalaxy(pos.x, pos.y);
+            const galaxyX = Math.round(point.x);
+            const galaxyY = Math.round(point.y);
             
             document.getElementById('pointX').value = galaxyX;
             document.getElementById('pointY').value = galaxyY;
@@ -504,6 +715,8 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
             performInteraction(currentState, galaxyX, galaxyY);
         });
 
+        document.getElementById('showAxes').addEventListener('change', drawImages);
+
         // Allow Enter key to evaluate expressions
         document.getElementById('expression').addEventListener('keypress', function(e) {
             if (e.key === 'Enter' && !e.shiftKey) {
@@ -512,21 +725,143 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
             }
         });
 
-        // Initialize canvas
+        // Symbol browser
+        function symbolLinks(label, names) {
+            const div = document.createElement('div');
+            div.className = 'symbol-list';
+            div.appendChild(document.createTextNode(label + ': '));
+            if (!names || names.length === 0) {
+                div.appendChild(document.createTextNode('(none)'));
+            }
+            (names || []).forEach(name => {
+                const a = document.createElement('a');
+                a.textContent = name;
+                a.onclick = () => browseSymbol(name);
+                div.appendChild(a);
+            });
+            return div;
+        }
+
+        async function loadPrograms() {
+            const select = document.getElementById('browseProgram');
+            try {
+                const response = await fetch('/programs');
+                const data = await response.json();
+                select.innerHTML = '';
+                (data.programs || []).forEach(program => {
+                    const option = document.createElement('option');
+                    option.value = program.name;
+                    option.textContent = program.name + ' (' + program.symbols + ' symbols)';
+                    select.appendChild(option);
+                });
+                selectProgram();
+            } catch (error) {
+                showSymbolResult(document.createTextNode('Network error: ' + error.message), true);
+            }
+        }
+
+        async function selectProgram() {
+            const program = document.getElementById('browseProgram').value;
+            const base = '/programs/' + encodeURIComponent(program) + '/graph';
+            document.getElementById('graphJSON').href = base;
+            document.getElementById('graphDot').href = base + '?format=dot';
+
+            const response = await fetch('/programs/' + encodeURIComponent(program));
+            const data = await response.json();
+            const entryPoints = document.getElementById('entryPoints');
+            entryPoints.innerHTML = '';
+            if (data.program) {
+                entryPoints.appendChild(symbolLinks('Entry points', data.program.entryPoints));
+            }
+        }
+
+        async function browseSymbol(name) {
+            if (!name) return;
+            document.getElementById('browseSymbol').value = name;
+            const program = document.getElementById('browseProgram').value;
+            try {
+                const response = await fetch('/programs/' + encodeURIComponent(program) + '/symbols/' + encodeURIComponent(name));
+                const data = await response.json();
+                if (data.error) {
+                    showSymbolResult(document.createTextNode('Error: ' + data.error), true);
+                    return;
+                }
+                const content = document.createElement('div');
+                const heading = document.createElement('h3');
+                heading.textContent = data.name;
+                content.appendChild(heading);
+                const definition = document.createElement('div');
+                definition.className = 'state-display';
+                definition.textContent = data.definition;
+                content.appendChild(definition);
+                content.appendChild(symbolLinks('References', data.references));
+                content.appendChild(symbolLinks('Referenced by', data.referencedBy));
+                showSymbolResult(content, false);
+            } catch (error) {
+                showSymbolResult(document.createTextNode('Network error: ' + error.message), true);
+            }
+        }
+
+        function showSymbolResult(content, isError) {
+            const resultDiv = document.getElementById('symbolResult');
+            resultDiv.innerHTML = '';
+            resultDiv.appendChild(content);
+            resultDiv.className = 'result' + (isError ? ' error' : '');
+            resultDiv.style.display = 'block';
+        }
+
+        document.getElementById('browseSymbol').addEventListener('keypress', function(e) {
+            if (e.key === 'Enter') {
+                browseSymbol(this.value.trim());
+            }
+        });
+
+        // Initialize canvas, opening the state of a permalink if any
+        const initialState = /*INITIAL_STATE*/null;
         clearCanvas();
+        if (initialState) {
+            applyInteractResult({ newstate: initialState.state, images: initialState.images, hash: initialState.hash });
+        }
+        loadPrograms();
     </script>
 </body>
 </html>`
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
+// routes are the handlers of the HTTP API and the web UI by pattern.
+var routes = map[string]http.HandlerFunc{
+	"/":                                 rootHandler,
+	"/eval":                             requestLimits.limit(evalHandler),
+	"/interact":                         requestLimits.limit(interactHandler),
+	"/interact/stream":                  requestLimits.limit(interactStreamHandler),
+	"/states/{hash}":                    stateHandler,
+	"/s/{hash}":                         permalinkHandler,
+	"/programs":                         programsHandler,
+	"/programs/{name}":                  programHandler,
+	"/programs/{name}/symbols/{symbol}": symbolHandler,
+	"/programs/{name}/graph":            graphHandler,
+	"/cache":                            cacheHandler,
+	"/debug/memory":                     memoryHandler,
+	"/aliens/send":                      aliensSendHandler,
+	"/metrics":                          metricsHandler,
+	"/healthz":                          healthzHandler,
+	"/readyz":                           readyzHandler,
+	"/version":                          versionHandler,
+	"/openapi.json":                     openapiHandler,
 }
 
-func main() {
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/eval", evalHandler)
-	http.HandleFunc("/interact", interactHandler)
+// newMux registers the routes, tags requests with their IDs and counts
+// them in the metrics.
+func newMux() http.Handler {
+	mux := http.NewServeMux()
+	for pattern, handler := range routes {
+		mux.HandleFunc(pattern, handler)
+	}
+	return withRequestID(serverMetrics.instrument(mux))
+}
 
-	fmt.Println("Server starting on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+func main() {
+	if err := runCLI(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "galaxy: %v\n", err)
+		os.Exit(1)
+	}
 }
@@ -3,11 +3,22 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	if err := loadProgram("galaxy.txt"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
 func TestEvalEndpoint(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -15,35 +26,35 @@ func TestEvalEndpoint(t *testing.T) {
 		body           interface{}
 		expectedStatus int
 		expectedError  string
-		expectedResult interface{}
+		expectedResult Value
 	}{
 		{
 			name:           "valid addition expression",
 			method:         "POST",
 			body:           EvalRequest{Expression: "ap ap add 2 3"},
 			expectedStatus: 200,
-			expectedResult: int64(5),
+			expectedResult: Int(5),
 		},
 		{
 			name:           "valid multiplication expression",
 			method:         "POST",
 			body:           EvalRequest{Expression: "ap ap mul 4 5"},
 			expectedStatus: 200,
-			expectedResult: int64(20),
+			expectedResult: Int(20),
 		},
 		{
 			name:           "valid number expression",
 			method:         "POST",
 			body:           EvalRequest{Expression: "42"},
 			expectedStatus: 200,
-			expectedResult: int64(42),
+			expectedResult: Int(42),
 		},
 		{
 			name:           "partial application expression",
 			method:         "POST",
 			body:           EvalRequest{Expression: "ap add 5"},
 			expectedStatus: 200,
-			expectedResult: "ap add 5", // Partial applications return their string representation
+			expectedResult: Unevaluated{&Ap{Left: Symbol("add"), Right: Number(5)}}, // Partial applications are tagged unevaluated
 		},
 		{
 			name:           "invalid method GET",
@@ -111,19 +122,8 @@ func TestEvalEndpoint(t *testing.T) {
 				if response.Error != "" {
 					t.Errorf("Unexpected error: %s", response.Error)
 				} else {
-					// Handle JSON number conversion - JSON unmarshals numbers as float64 by default
-					if expectedNum, ok := tt.expectedResult.(int64); ok {
-						if actualNum, ok := response.Result.(float64); ok {
-							if int64(actualNum) != expectedNum {
-								t.Errorf("Expected result %v, got %v", expectedNum, int64(actualNum))
-							}
-						} else {
-							t.Errorf("Expected result %v (int64), got %v (%T)", tt.expectedResult, response.Result, response.Result)
-						}
-					} else {
-						if response.Result != tt.expectedResult {
-							t.Errorf("Expected result %v, got %v", tt.expectedResult, response.Result)
-						}
+					if response.Result == nil || !reflect.DeepEqual(tt.expectedResult, response.Result.Value) {
+						t.Errorf("Expected result %v, got %v", tt.expectedResult, response.Result)
 					}
 				}
 			}
@@ -291,10 +291,11 @@ func TestRootEndpoint(t *testing.T) {
 
 // Test helper to ensure the global program is loaded
 func TestGalaxyLoaded(t *testing.T) {
-	if galaxy == nil {
-		t.Error("galaxy should be loaded during init")
+	galaxy, ok := programs.get(defaultProgram)
+	if !ok {
+		t.Fatal("galaxy should be loaded by loadProgram")
 	}
-	if len(galaxy) == 0 {
+	if len(galaxy.symbols) == 0 {
 		t.Error("galaxy should contain parsed symbols")
 	}
 }
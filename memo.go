@@ -0,0 +1,209 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default limits of the interaction cache.
+const (
+	defaultCacheSize = 1024
+	defaultCacheTTL  = 10 * time.Minute
+)
+
+// interactKey identifies one galaxy call. The program is identified by name
+// and load time so that a reloaded program doesn't see results of the old
+// one.
+type interactKey struct {
+	program  string
+	loadedAt time.Time
+	state    exprHash
+	x, y     int64
+}
+
+type cachedInteraction struct {
+	key     interactKey
+	resp    InteractResponse
+	expires time.Time
+}
+
+// CacheStats describes the interaction cache.
+type CacheStats struct {
+	Entries     int     `json:"entries"`
+	MaxEntries  int     `json:"maxEntries"`
+	TTL         string  `json:"ttl"`
+	Hits        uint64  `json:"hits"`
+	Misses      uint64  `json:"misses"`
+	Evictions   uint64  `json:"evictions"`
+	Expirations uint64  `json:"expirations"`
+	HitRate     float64 `json:"hitRate"`
+}
+
+type CacheResponse struct {
+	*CacheStats
+	Error string `json:"error,omitempty"`
+}
+
+// interactCache is an LRU cache of interaction responses keyed by the
+// structural hash of the state and the clicked point, so that revisiting a
+// screen doesn't evaluate galaxy again. Entries older than ttl are treated
+// as absent; a maxEntries of 0 disables the cache.
+type interactCache struct {
+	mu          sync.Mutex
+	maxEntries  int
+	ttl         time.Duration
+	entries     map[interactKey]*list.Element
+	lru         *list.List
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
+	now         func() time.Time
+}
+
+var interactResults = newInteractCache(defaultCacheSize, defaultCacheTTL)
+
+func newInteractCache(maxEntries int, ttl time.Duration) *interactCache {
+	return &interactCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    map[interactKey]*list.Element{},
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// configure changes the limits of the cache, dropping entries that no
+// longer fit.
+func (c *interactCache) configure(maxEntries int, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEntries, c.ttl = maxEntries, ttl
+	c.evict()
+}
+
+func (c *interactCache) get(key interactKey) (InteractResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return InteractResponse{}, false
+	}
+	entry := elem.Value.(*cachedInteraction)
+	if c.ttl > 0 && c.now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		c.expirations++
+		c.misses++
+		return InteractResponse{}, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	return entry.resp, true
+}
+
+func (c *interactCache) put(key interactKey, resp InteractResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxEntries <= 0 {
+		return
+	}
+	resp.Hash = ""
+	entry := &cachedInteraction{key: key, resp: resp, expires: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.evict()
+}
+
+// evict drops the least recently used entries until the cache fits.
+func (c *interactCache) evict() {
+	for c.lru.Len() > max(c.maxEntries, 0) {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.entries, elem.Value.(*cachedInteraction).key)
+		c.evictions++
+	}
+}
+
+// flush empties the cache. The counters are kept.
+func (c *interactCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[interactKey]*list.Element{}
+	c.lru.Init()
+}
+
+func (c *interactCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := CacheStats{
+		Entries:     c.lru.Len(),
+		MaxEntries:  c.maxEntries,
+		TTL:         c.ttl.String(),
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// cachedInteract runs interactWithEvents through interactResults. A cached
+// response is replayed as its "flag", "image" and "done" events. Only
+// interactions that finished with flag 0 without talking to the aliens are
+// cached: the aliens may answer differently next time, and the result of
+// the first call to galaxy is the same with or without send.
+func cachedInteract(ctx context.Context, prog *program, stateExpr Expr, x, y int64, send bool, emit func(InteractEvent)) (InteractResponse, error) {
+	key := interactKey{program: prog.name, loadedAt: prog.loadedAt, state: hashExpr(stateExpr), x: x, y: y}
+	if resp, ok := interactResults.get(key); ok {
+		emit(InteractEvent{Type: "flag", Flag: &resp.Flag})
+		for i, image := range resp.Images {
+			emit(InteractEvent{Type: "image", Layer: &i, Image: image})
+		}
+		emit(InteractEvent{Type: "done", Result: &resp})
+		return resp, nil
+	}
+
+	sent := false
+	resp, err := interactWithEvents(ctx, prog.symbols, stateExpr, x, y, send, func(ev InteractEvent) {
+		if ev.Type == "send" {
+			sent = true
+		}
+		emit(ev)
+	})
+	if err == nil && !sent && resp.Flag == 0 {
+		interactResults.put(key, resp)
+	}
+	return resp, err
+}
+
+// cacheHandler reports the interaction cache statistics on GET and empties
+// the cache on DELETE.
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		interactResults.flush()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(CacheResponse{Error: "Method not allowed"})
+		return
+	}
+
+	stats := interactResults.stats()
+	json.NewEncoder(w).Encode(CacheResponse{CacheStats: &stats})
+}
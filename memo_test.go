@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInteractCacheLRU(t *testing.T) {
+	c := newInteractCache(2, 0)
+	key := func(x int64) interactKey { return interactKey{program: "galaxy", x: x} }
+	c.put(key(1), InteractResponse{NewState: "1", Hash: "h"})
+	c.put(key(2), InteractResponse{NewState: "2"})
+
+	resp, ok := c.get(key(1))
+	assert.True(t, ok)
+	assert.Equal(t, InteractResponse{NewState: "1"}, resp)
+
+	// 2 is now the least recently used entry.
+	c.put(key(3), InteractResponse{NewState: "3"})
+	_, ok = c.get(key(2))
+	assert.False(t, ok)
+	_, ok = c.get(key(3))
+	assert.True(t, ok)
+
+	stats := c.stats()
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.InDelta(t, 2.0/3, stats.HitRate, 1e-9)
+
+	c.configure(0, 0)
+	assert.Equal(t, 0, c.stats().Entries)
+	c.put(key(1), InteractResponse{})
+	assert.Equal(t, 0, c.stats().Entries)
+}
+
+func TestInteractCacheTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newInteractCache(10, time.Minute)
+	c.now = func() time.Time { return now }
+	key := interactKey{program: "galaxy"}
+	c.put(key, InteractResponse{NewState: "1"})
+
+	now = now.Add(time.Minute)
+	_, ok := c.get(key)
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = c.get(key)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), c.stats().Expirations)
+	assert.Equal(t, 0, c.stats().Entries)
+}
+
+func TestInteractCacheEndpoint(t *testing.T) {
+	serve(http.MethodDelete, "/cache", "")
+	before := interactResults.stats()
+
+	// The state is the same structure spelled differently, so the second
+	// request hits the cache.
+	body := `{"state": "nil", "point": {"x": 0, "y": 0}}`
+	first := serve(http.MethodPost, "/interact", body)
+	second := serve(http.MethodPost, "/interact", strings.Replace(body, `"nil"`, `" nil "`, 1))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+
+	rr := serve(http.MethodGet, "/cache", "")
+	var resp CacheResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Entries)
+	assert.Equal(t, before.Hits+1, resp.Hits)
+	assert.Equal(t, before.Misses+1, resp.Misses)
+
+	rr = serve(http.MethodDelete, "/cache", "")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, 0, resp.Entries)
+
+	rr = serve(http.MethodPost, "/cache", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.JSONEq(t, `{"error": "Method not allowed"}`, rr.Body.String())
+}
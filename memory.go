@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// memoryCheckInterval is how often watchMemory compares the heap against
+// its limit.
+const memoryCheckInterval = time.Second
+
+// maxMemorySymbols bounds how many definitions a program's report lists.
+const maxMemorySymbols = 10
+
+// SymbolMemory reports the size of one top-level definition in
+// application nodes.
+type SymbolMemory struct {
+	Symbol string `json:"symbol"`
+	Nodes  int    `json:"nodes"`
+}
+
+// ProgramMemory reports the size of a program: the distinct application
+// nodes of its definitions and its largest definitions. Generation counts
+// how often its evaluation cache has been flushed.
+type ProgramMemory struct {
+	Name       string         `json:"name"`
+	Generation int64          `json:"generation"`
+	Nodes      int            `json:"nodes"`
+	Symbols    []SymbolMemory `json:"symbols"`
+}
+
+// MemoryResponse reports the heap and the programs. The values cached in
+// the programs' nodes are written by evaluations without synchronization,
+// so they are not inspected: EvalSteps and CacheHits, the reduction steps
+// taken and the evaluations answered from the cache since the server
+// started, show how much the cache is used, and the heap how much it holds.
+type MemoryResponse struct {
+	HeapAlloc uint64          `json:"heapAlloc"`
+	HeapLimit uint64          `json:"heapLimit,omitempty"`
+	EvalSteps int64           `json:"evalSteps"`
+	CacheHits int64           `json:"cacheHits"`
+	Programs  []ProgramMemory `json:"programs"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// heapLimit is the heap size above which watchMemory flushes the evaluation
+// caches, 0 for no limit.
+var heapLimit uint64
+
+// memory walks the program's definitions and reports its size. Only the
+// structure of the nodes, which doesn't change once the program is
+// registered, is read.
+func (p *program) memory() ProgramMemory {
+	report := ProgramMemory{Name: p.name, Generation: p.generation.Load(), Symbols: []SymbolMemory{}}
+	all := map[*Ap]bool{}
+	for name, expr := range p.symbols {
+		sym := SymbolMemory{Symbol: string(name)}
+		seen := map[*Ap]bool{}
+		stack := []Expr{expr}
+		for len(stack) > 0 {
+			a, ok := stack[len(stack)-1].(*Ap)
+			stack = stack[:len(stack)-1]
+			if !ok || seen[a] {
+				continue
+			}
+			seen[a] = true
+			all[a] = true
+			stack = append(stack, a.Left, a.Right)
+		}
+		sym.Nodes = len(seen)
+		report.Symbols = append(report.Symbols, sym)
+	}
+	report.Nodes = len(all)
+	sort.Slice(report.Symbols, func(i, j int) bool {
+		a, b := report.Symbols[i], report.Symbols[j]
+		if a.Nodes != b.Nodes {
+			return a.Nodes > b.Nodes
+		}
+		return a.Symbol < b.Symbol
+	})
+	if len(report.Symbols) > maxMemorySymbols {
+		report.Symbols = report.Symbols[:maxMemorySymbols]
+	}
+	return report
+}
+
+// flushEvalCache replaces p in the registry with a copy of its definitions
+// that has nothing cached, which makes everything evaluation built against
+// p unreachable once the evaluations in flight, which keep p, finish. The
+// cached values are not cleared in place because those evaluations read
+// and write them without synchronization. The copy starts a new
+// generation. It returns the program now registered under p's name, which
+// is not a copy of p when p was replaced or removed in the meantime.
+func (r *programRegistry) flushEvalCache(p *program) *program {
+	fresh := r.newProgram(p.name, copyDefinitions(p.symbols))
+	fresh.path, fresh.modTime, fresh.size, fresh.hash = p.path, p.modTime, p.size, p.hash
+	// The interaction cache may keep its results: the program is the same.
+	fresh.loadedAt = p.loadedAt
+	fresh.generation.Store(p.generation.Load() + 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.programs[p.name] != p {
+		return r.programs[p.name]
+	}
+	r.programs[p.name] = fresh
+	return fresh
+}
+
+func heapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// watchMemory flushes the evaluation caches of every program whenever the
+// heap grows beyond limit, until ctx is done.
+func (r *programRegistry) watchMemory(ctx context.Context, limit uint64) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if heap := heapAlloc(); heap > limit {
+				flushed := r.list()
+				for _, p := range flushed {
+					r.flushEvalCache(p)
+				}
+				runtime.GC()
+				slog.Warn("heap over limit, dropped cached values", "heap", heap, "programs", len(flushed))
+			}
+		}
+	}
+}
+
+// memoryHandler reports the evaluation caches of all programs on GET and
+// flushes them on DELETE.
+func memoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		for _, p := range programs.list() {
+			programs.flushEvalCache(p)
+		}
+		runtime.GC()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(MemoryResponse{Error: "Method not allowed"})
+		return
+	}
+
+	resp := MemoryResponse{
+		HeapAlloc: heapAlloc(),
+		HeapLimit: heapLimit,
+		EvalSteps: serverMetrics.steps.Load(),
+		CacheHits: serverMetrics.cacheHits.Load(),
+		Programs:  []ProgramMemory{},
+	}
+	for _, p := range programs.list() {
+		resp.Programs = append(resp.Programs, p.memory())
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachedNodes counts the nodes of symbols that hold a cached value.
+func cachedNodes(symbols map[Symbol]Expr) int {
+	seen := map[*Ap]bool{}
+	var walk func(Expr)
+	walk = func(expr Expr) {
+		if a, ok := expr.(*Ap); ok && !seen[a] {
+			seen[a] = true
+			walk(a.Left)
+			walk(a.Right)
+		}
+	}
+	for _, expr := range symbols {
+		walk(expr)
+	}
+	cached := 0
+	for a := range seen {
+		if a.v != nil {
+			cached++
+		}
+	}
+	return cached
+}
+
+func TestEvalCacheFlush(t *testing.T) {
+	registry := newProgramRegistry()
+	p := registry.add("test", map[Symbol]Expr{
+		":1": &Ap{Left: &Ap{Left: Symbol("add"), Right: Number(1)}, Right: Number(2)},
+		":2": &Ap{Left: Symbol("neg"), Right: Symbol(":1")},
+	})
+	report := p.memory()
+	assert.Equal(t, 3, report.Nodes)
+	assert.Equal(t, []SymbolMemory{{Symbol: ":1", Nodes: 2}, {Symbol: ":2", Nodes: 1}}, report.Symbols)
+
+	result, err := evalContext(context.Background(), Symbol(":2"), p.symbols)
+	require.NoError(t, err)
+	assert.Equal(t, Number(-3), result)
+	assert.Equal(t, 1, cachedNodes(p.symbols))
+
+	// The flush swaps in an uncached copy and leaves the old program, which
+	// evaluations in flight may be using, alone.
+	fresh := registry.flushEvalCache(p)
+	assert.NotSame(t, p, fresh)
+	got, ok := registry.get("test")
+	require.True(t, ok)
+	assert.Same(t, fresh, got)
+	assert.Equal(t, p.loadedAt, fresh.loadedAt)
+	assert.Equal(t, 1, cachedNodes(p.symbols))
+	assert.Zero(t, cachedNodes(fresh.symbols))
+	report = fresh.memory()
+	assert.Equal(t, int64(1), report.Generation)
+	assert.Equal(t, 3, report.Nodes)
+
+	// Flushing a program that was replaced keeps the replacement.
+	assert.Same(t, fresh, registry.flushEvalCache(p))
+
+	// Flushed programs evaluate the same.
+	result, err = evalContext(context.Background(), Symbol(":2"), fresh.symbols)
+	require.NoError(t, err)
+	assert.Equal(t, Number(-3), result)
+}
+
+func TestMemoryEndpoint(t *testing.T) {
+	interactResults.flush()
+	serve(http.MethodPost, "/interact", `{"state": "nil", "point": {"x": 0, "y": 0}}`)
+
+	rr := serve(http.MethodGet, "/debug/memory", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp MemoryResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.NotZero(t, resp.HeapAlloc)
+	require.NotEmpty(t, resp.Programs)
+	galaxy := resp.Programs[0]
+	assert.Equal(t, "galaxy", galaxy.Name)
+	assert.NotZero(t, resp.EvalSteps)
+	assert.NotZero(t, galaxy.Nodes)
+	assert.Len(t, galaxy.Symbols, maxMemorySymbols)
+	assert.GreaterOrEqual(t, galaxy.Symbols[0].Nodes, galaxy.Symbols[1].Nodes)
+
+	rr = serve(http.MethodDelete, "/debug/memory", "")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, galaxy.Nodes, resp.Programs[0].Nodes)
+	assert.Equal(t, galaxy.Generation+1, resp.Programs[0].Generation)
+
+	rr = serve(http.MethodPut, "/debug/memory", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+// TestEvalCacheFlushWhileEvaluating is meant for the race detector: flushes
+// and memory reports must not touch the values an evaluation is caching.
+func TestEvalCacheFlushWhileEvaluating(t *testing.T) {
+	registry := newProgramRegistry()
+	symbols, err := parseProgramText("pwr2 = ap ap s ap ap c ap eq 0 1 ap ap b ap mul 2 ap ap b pwr2 ap add -1\nmain = ap pwr2 8\n")
+	require.NoError(t, err)
+	registry.add("test", symbols)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 200 {
+			p, _ := registry.get("test")
+			result, err := evalContext(context.Background(), Symbol("main"), p.symbols)
+			assert.NoError(t, err)
+			assert.Equal(t, Number(256), result)
+		}
+	}()
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+			p, _ := registry.get("test")
+			p.memory()
+			registry.flushEvalCache(p)
+		}
+	}
+	p, _ := registry.get("test")
+	assert.Positive(t, p.generation.Load())
+}
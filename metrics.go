@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency
+// histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// histogram counts observations into buckets. It is guarded by the mutex of
+// the metrics it belongs to.
+type histogram struct {
+	// counts[i] counts the observations in bucket i, not the ones below it;
+	// the last count is for the observations above every bucket.
+	counts []uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(latencyBuckets, v)]++
+	h.sum += v
+}
+
+func (h *histogram) count() uint64 {
+	var n uint64
+	for _, c := range h.counts {
+		n += c
+	}
+	return n
+}
+
+type requestLabels struct {
+	handler string
+	code    int
+}
+
+// metrics collects what /metrics reports about the server. The counters
+// of evaluations are updated by every evaluator, the others by the HTTP
+// middleware and the interactions.
+type metrics struct {
+	// steps counts reduction steps and cacheHits the evaluations answered
+	// from Ap.v.
+	steps     atomic.Int64
+	cacheHits atomic.Int64
+	// inFlight counts requests being served and sessions the open event
+	// streams of /interact/stream.
+	inFlight atomic.Int64
+	sessions atomic.Int64
+
+	mu       sync.Mutex
+	requests map[requestLabels]*histogram
+	sends    map[string]*histogram
+}
+
+var serverMetrics = newMetrics()
+
+func newMetrics() *metrics {
+	return &metrics{requests: map[requestLabels]*histogram{}, sends: map[string]*histogram{}}
+}
+
+func (m *metrics) observeRequest(handler string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := requestLabels{handler, code}
+	h, ok := m.requests[key]
+	if !ok {
+		h = newHistogram()
+		m.requests[key] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// observeSend records a round trip to the aliens, which ended with err.
+func (m *metrics) observeSend(err error, d time.Duration) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.sends[result]
+	if !ok {
+		h = newHistogram()
+		m.sends[result] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// instrument counts the requests next serves and their latency by the
+// pattern they matched and their status.
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		// The mux sets the pattern of the request it was handed.
+		handler := r.Pattern
+		if handler == "" {
+			handler = "unmatched"
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		m.observeRequest(handler, rec.status, time.Since(start))
+	})
+}
+
+// label formats label pairs for the Prometheus text format.
+func label(pairs ...string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(pairs[i])
+		sb.WriteString(`="`)
+		sb.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1]))
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += h.counts[i]
+		writeSample(w, name+"_bucket", labels+sep+label("le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", labels+sep+label("le", "+Inf"), float64(h.count()))
+	writeSample(w, name+"_sum", labels, h.sum)
+	writeSample(w, name+"_count", labels, float64(h.count()))
+}
+
+// write writes the metrics in the Prometheus text format, sorted so that
+// scrapes are easy to compare.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	requests := make([]requestLabels, 0, len(m.requests))
+	for key := range m.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].handler != requests[j].handler {
+			return requests[i].handler < requests[j].handler
+		}
+		return requests[i].code < requests[j].code
+	})
+	writeHeader(w, "galaxy_http_requests_total", "counter", "HTTP requests served, by handler pattern and status code.")
+	for _, key := range requests {
+		writeSample(w, "galaxy_http_requests_total", label("handler", key.handler, "code", strconv.Itoa(key.code)), float64(m.requests[key].count()))
+	}
+	writeHeader(w, "galaxy_http_request_duration_seconds", "histogram", "Latency of HTTP requests, by handler pattern and status code.")
+	for _, key := range requests {
+		writeHistogram(w, "galaxy_http_request_duration_seconds", label("handler", key.handler, "code", strconv.Itoa(key.code)), m.requests[key])
+	}
+	results := make([]string, 0, len(m.sends))
+	for result := range m.sends {
+		results = append(results, result)
+	}
+	sort.Strings(results)
+	writeHeader(w, "galaxy_sends_total", "counter", "Round trips of data sent to the aliens, by result.")
+	for _, result := range results {
+		writeSample(w, "galaxy_sends_total", label("result", result), float64(m.sends[result].count()))
+	}
+	writeHeader(w, "galaxy_send_duration_seconds", "histogram", "Latency of round trips to the aliens, by result.")
+	for _, result := range results {
+		writeHistogram(w, "galaxy_send_duration_seconds", label("result", result), m.sends[result])
+	}
+	m.mu.Unlock()
+
+	writeHeader(w, "galaxy_http_requests_in_flight", "gauge", "HTTP requests being served.")
+	writeSample(w, "galaxy_http_requests_in_flight", "", float64(m.inFlight.Load()))
+	writeHeader(w, "galaxy_sessions_active", "gauge", "Open interaction event streams.")
+	writeSample(w, "galaxy_sessions_active", "", float64(m.sessions.Load()))
+	writeHeader(w, "galaxy_states_stored", "gauge", "Interaction states kept for permalinks.")
+	writeSample(w, "galaxy_states_stored", "", float64(states.len()))
+	writeHeader(w, "galaxy_eval_steps_total", "counter", "Reduction steps taken by evaluations.")
+	writeSample(w, "galaxy_eval_steps_total", "", float64(m.steps.Load()))
+	writeHeader(w, "galaxy_eval_cache_hits_total", "counter", "Evaluations answered from the cached values of applications.")
+	writeSample(w, "galaxy_eval_cache_hits_total", "", float64(m.cacheHits.Load()))
+
+	writeRuntimeMetrics(w)
+}
+
+// writeRuntimeMetrics writes the Go runtime statistics.
+func writeRuntimeMetrics(w io.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	writeHeader(w, "go_info", "gauge", "Version of Go the server was built with.")
+	writeSample(w, "go_info", label("version", runtime.Version()), 1)
+	writeHeader(w, "go_goroutines", "gauge", "Number of goroutines.")
+	writeSample(w, "go_goroutines", "", float64(runtime.NumGoroutine()))
+	writeHeader(w, "go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+	writeSample(w, "go_memstats_heap_alloc_bytes", "", float64(stats.HeapAlloc))
+	writeHeader(w, "go_memstats_heap_objects", "gauge", "Number of allocated heap objects.")
+	writeSample(w, "go_memstats_heap_objects", "", float64(stats.HeapObjects))
+	writeHeader(w, "go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the system.")
+	writeSample(w, "go_memstats_sys_bytes", "", float64(stats.Sys))
+	writeHeader(w, "go_memstats_alloc_bytes_total", "counter", "Bytes allocated for heap objects, including freed ones.")
+	writeSample(w, "go_memstats_alloc_bytes_total", "", float64(stats.TotalAlloc))
+	writeHeader(w, "go_gc_cycles_total", "counter", "Completed garbage collection cycles.")
+	writeSample(w, "go_gc_cycles_total", "", float64(stats.NumGC))
+	writeHeader(w, "go_gc_pause_seconds_total", "counter", "Time the world was stopped for garbage collection.")
+	writeSample(w, "go_gc_pause_seconds_total", "", time.Duration(stats.PauseTotalNs).Seconds())
+}
+
+// metricsHandler serves the metrics in the Prometheus text format on GET.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	serverMetrics.write(w)
+}
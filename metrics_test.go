@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape fetches /metrics and returns its samples by name and labels.
+func scrape(t *testing.T) map[string]float64 {
+	rr := serve(http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+	samples := map[string]float64{}
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		require.Positive(t, i, line)
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		require.NoError(t, err, line)
+		samples[line[:i]] = v
+	}
+	return samples
+}
+
+func TestHistogramOutput(t *testing.T) {
+	m := newMetrics()
+	m.observeRequest("/eval", http.StatusOK, 3*time.Millisecond)
+	m.observeRequest("/eval", http.StatusOK, 2*time.Second)
+	m.observeRequest("/eval", http.StatusOK, 2*time.Minute)
+	m.observeRequest("/eval", http.StatusBadRequest, time.Millisecond)
+	var sb strings.Builder
+	m.write(&sb)
+	out := sb.String()
+
+	assert.Contains(t, out, "# TYPE galaxy_http_request_duration_seconds histogram\n")
+	assert.Contains(t, out, `galaxy_http_requests_total{handler="/eval",code="200"} 3`+"\n"+`galaxy_http_requests_total{handler="/eval",code="400"} 1`+"\n")
+	assert.Contains(t, out, `galaxy_http_request_duration_seconds_bucket{handler="/eval",code="200",le="0.005"} 1`+"\n")
+	assert.Contains(t, out, `galaxy_http_request_duration_seconds_bucket{handler="/eval",code="200",le="1"} 1`+"\n")
+	assert.Contains(t, out, `galaxy_http_request_duration_seconds_bucket{handler="/eval",code="200",le="2.5"} 2`+"\n")
+	assert.Contains(t, out, `galaxy_http_request_duration_seconds_bucket{handler="/eval",code="200",le="60"} 2`+"\n")
+	assert.Contains(t, out, `galaxy_http_request_duration_seconds_bucket{handler="/eval",code="200",le="+Inf"} 3`+"\n")
+	assert.Contains(t, out, `galaxy_http_request_duration_seconds_sum{handler="/eval",code="200"} 122.003`+"\n")
+	assert.Contains(t, out, `galaxy_http_request_duration_seconds_count{handler="/eval",code="200"} 3`+"\n")
+}
+
+func TestLabelEscaping(t *testing.T) {
+	assert.Equal(t, `a="x\"y\\z\n"`, label("a", "x\"y\\z\n"))
+	assert.Equal(t, `a="1",b="2"`, label("a", "1", "b", "2"))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	before := scrape(t)
+	rr := serve(http.MethodPost, "/eval", `{"expression": "ap ap add 1 2"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	serve(http.MethodGet, "/programs/missing", "")
+	after := scrape(t)
+
+	assert.Equal(t, before[`galaxy_http_requests_total{handler="/eval",code="200"}`]+1, after[`galaxy_http_requests_total{handler="/eval",code="200"}`])
+	assert.Equal(t, before[`galaxy_http_requests_total{handler="/programs/{name}",code="404"}`]+1, after[`galaxy_http_requests_total{handler="/programs/{name}",code="404"}`])
+	assert.Equal(t, before[`galaxy_http_requests_total{handler="/metrics",code="200"}`]+1, after[`galaxy_http_requests_total{handler="/metrics",code="200"}`])
+	assert.Greater(t, after["galaxy_eval_steps_total"], before["galaxy_eval_steps_total"])
+	assert.Equal(t, float64(1), after["galaxy_http_requests_in_flight"])
+	assert.Positive(t, after["go_goroutines"])
+	assert.Positive(t, after["go_memstats_heap_alloc_bytes"])
+	assert.Contains(t, after, `go_info{version="`+runtime.Version()+`"}`)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPost, "/metrics", "").Code)
+}
+
+func TestMetricsCountCacheHits(t *testing.T) {
+	symbols, err := parseProgramText("x = ap ap add 1 2\n")
+	require.NoError(t, err)
+	before := serverMetrics.cacheHits.Load()
+	ev := newEvaluator(context.Background(), symbols)
+	expr := &Ap{Left: &Ap{Left: Symbol("add"), Right: Symbol("x")}, Right: Symbol("x")}
+	assert.Equal(t, Number(6), ev.eval(expr))
+	assert.Equal(t, Number(6), ev.eval(expr))
+	assert.Greater(t, serverMetrics.cacheHits.Load(), before+1)
+}
+
+func TestMetricsCountSends(t *testing.T) {
+	symbols, err := parseProgramText(senderProgram)
+	require.NoError(t, err)
+	before := scrape(t)
+
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		return &Ap{Left: &Ap{Left: cons, Right: Number(7)}, Right: Number(8)}, nil
+	}))
+	_, err = interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	require.NoError(t, err)
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		return nil, errors.New("link down")
+	}))
+	_, err = interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	require.Error(t, err)
+
+	after := scrape(t)
+	assert.Equal(t, before[`galaxy_sends_total{result="ok"}`]+1, after[`galaxy_sends_total{result="ok"}`])
+	assert.Equal(t, before[`galaxy_sends_total{result="error"}`]+1, after[`galaxy_sends_total{result="error"}`])
+	assert.Equal(t, after[`galaxy_sends_total{result="ok"}`], after[`galaxy_send_duration_seconds_count{result="ok"}`])
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// modulate encodes a data expression, built only from numbers, nil and cons
+// cells, in the bit string format used to talk to the aliens.
+func modulate(expr Expr) (string, error) {
+	var sb strings.Builder
+	if err := modulateTo(&sb, expr); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func modulateTo(sb *strings.Builder, expr Expr) error {
+	switch e := resolve(expr).(type) {
+	case Number:
+		sb.WriteString(modulateNumber(int64(e)))
+		return nil
+	case Symbol:
+		if e == "nil" {
+			sb.WriteString("00")
+			return nil
+		}
+	case *Ap:
+		if inner, ok := resolve(e.Left).(*Ap); ok && inner.Left == cons {
+			sb.WriteString("11")
+			if err := modulateTo(sb, inner.Right); err != nil {
+				return err
+			}
+			return modulateTo(sb, e.Right)
+		}
+	}
+	return fmt.Errorf("cannot modulate %s", printExpr(expr))
+}
+
+// modulateNumber writes the sign, the width in nibbles as a unary prefix,
+// and then the magnitude in binary.
+func modulateNumber(n int64) string {
+	if n == 0 {
+		return "010"
+	}
+	var sb strings.Builder
+	if n > 0 {
+		sb.WriteString("01")
+	} else {
+		sb.WriteString("10")
+	}
+	magnitude := new(big.Int).Abs(big.NewInt(n))
+	bits := magnitude.BitLen()
+	width := (bits + 3) / 4 * 4
+	sb.WriteString(strings.Repeat("1", width/4))
+	sb.WriteString("0")
+	sb.WriteString(fmt.Sprintf("%0*s", width, magnitude.Text(2)))
+	return sb.String()
+}
+
+var errTruncatedSignal = errors.New("truncated signal")
+
+// demodulate decodes a bit string produced by modulate.
+func demodulate(s string) (Expr, error) {
+	expr, rest, err := demodulatePrefix(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("trailing bits after signal: %q", rest)
+	}
+	return expr, nil
+}
+
+func demodulatePrefix(s string) (Expr, string, error) {
+	if len(s) < 2 {
+		return nil, "", errTruncatedSignal
+	}
+	tag, rest := s[:2], s[2:]
+	switch tag {
+	case "00":
+		return Symbol("nil"), rest, nil
+	case "11":
+		head, rest, err := demodulatePrefix(rest)
+		if err != nil {
+			return nil, "", err
+		}
+		tail, rest, err := demodulatePrefix(rest)
+		if err != nil {
+			return nil, "", err
+		}
+		return &Ap{Left: &Ap{Left: cons, Right: head}, Right: tail}, rest, nil
+	case "01", "10":
+		nibbles := strings.IndexByte(rest, '0')
+		if nibbles < 0 {
+			return nil, "", errTruncatedSignal
+		}
+		rest = rest[nibbles+1:]
+		width := nibbles * 4
+		if len(rest) < width {
+			return nil, "", errTruncatedSignal
+		}
+		if width > 64 {
+			return nil, "", fmt.Errorf("number of %d bits does not fit in 64 bits", width)
+		}
+		var n uint64
+		for _, c := range rest[:width] {
+			switch c {
+			case '0':
+				n <<= 1
+			case '1':
+				n = n<<1 | 1
+			default:
+				return nil, "", fmt.Errorf("invalid bit %q", c)
+			}
+		}
+		// The magnitude of the smallest int64 is one more than that of
+		// the largest.
+		if tag == "10" {
+			if n > math.MaxInt64+1 {
+				return nil, "", fmt.Errorf("number -%d does not fit in 64 bits", n)
+			}
+			return Number(-int64(n)), rest[width:], nil
+		}
+		if n > math.MaxInt64 {
+			return nil, "", fmt.Errorf("number %d does not fit in 64 bits", n)
+		}
+		return Number(int64(n)), rest[width:], nil
+	default:
+		return nil, "", fmt.Errorf("invalid signal tag %q", tag)
+	}
+}
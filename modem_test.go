@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModem(t *testing.T) {
+	for _, tt := range []struct {
+		expr     string
+		expected string
+	}{
+		{"0", "010"},
+		{"1", "01100001"},
+		{"-1", "10100001"},
+		{"15", "01101111"},
+		{"16", "0111000010000"},
+		{"255", "0111011111111"},
+		{"256", "011110000100000000"},
+		{"nil", "00"},
+		{"ap ap cons nil nil", "110000"},
+		{"ap ap cons 0 nil", "1101000"},
+		{"ap ap cons 1 2", "110110000101100010"},
+		{"ap ap cons 1 ap ap cons 2 nil", "1101100001110110001000"},
+	} {
+		expr, err := parseLine(tt.expr)
+		require.NoError(t, err)
+		bits, err := modulate(expr)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, bits, tt.expr)
+
+		decoded, err := demodulate(bits)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expr, printExpr(decoded))
+	}
+
+	for _, n := range []int64{math.MaxInt64, math.MinInt64 + 1, 123229502148636} {
+		decoded, err := demodulate(modulateNumber(n))
+		require.NoError(t, err)
+		assert.Equal(t, Number(n), decoded)
+	}
+}
+
+func TestModemErrors(t *testing.T) {
+	_, err := modulate(&Ap{Left: Symbol("add"), Right: Number(1)})
+	assert.EqualError(t, err, "cannot modulate ap add 1")
+
+	for _, bits := range []string{"", "1", "11", "0111", "01100", "0100", "2200"} {
+		_, err := demodulate(bits)
+		assert.Error(t, err, bits)
+	}
+}
+
+func TestModemInt64Range(t *testing.T) {
+	for _, n := range []int64{math.MaxInt64, math.MinInt64, math.MinInt64 + 1} {
+		bits, err := modulate(Number(n))
+		require.NoError(t, err)
+		expr, err := demodulate(bits)
+		require.NoError(t, err)
+		assert.Equal(t, Number(n), expr)
+	}
+
+	// 64-bit magnitudes beyond the int64 range don't wrap around.
+	prefix := strings.Repeat("1", 16) + "0"
+	_, err := demodulate("01" + prefix + "1" + strings.Repeat("0", 63))
+	assert.EqualError(t, err, "number 9223372036854775808 does not fit in 64 bits")
+	_, err = demodulate("10" + prefix + "1" + strings.Repeat("0", 62) + "1")
+	assert.EqualError(t, err, "number -9223372036854775809 does not fit in 64 bits")
+	_, err = demodulate("01" + prefix + strings.Repeat("1", 64))
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// defaultNormalizeBudget bounds the steps of a normalization that doesn't
+// set its own budget: unlike weak head normal form, the full normal form of
+// a recursive function never ends.
+const defaultNormalizeBudget = 1_000_000
+
+// maxNormalizeNesting bounds how deep normalize descends, which also bounds
+// its stack.
+const maxNormalizeNesting = 100_000
+
+// stepBudget is a number of reduction steps shared by all the evaluations
+// under a context, so that a request evaluating several expressions, such
+// as an interaction sending to the aliens, takes no more steps in total.
+// Steps spent against a budget are also spent against the one it was
+// carved out of.
+type stepBudget struct {
+	limit  int64
+	spent  atomic.Int64
+	parent *stepBudget
+}
+
+// remaining returns how many steps are left, which is negative once the
+// budget is overspent.
+func (b *stepBudget) remaining() int64 {
+	remaining := b.limit - b.spent.Load()
+	if b.parent != nil {
+		remaining = min(remaining, b.parent.remaining())
+	}
+	return remaining
+}
+
+func (b *stepBudget) spend(steps int64) {
+	for ; b != nil; b = b.parent {
+		b.spent.Add(steps)
+	}
+}
+
+type budgetKey struct{}
+
+// withBudget limits evaluations under ctx to steps reduction steps in
+// total, when steps is positive. A budget ctx already carries stays in
+// force when it has fewer steps left.
+func withBudget(ctx context.Context, steps int64) context.Context {
+	current := budgetFrom(ctx)
+	if steps <= 0 || current != nil && current.remaining() <= steps {
+		return ctx
+	}
+	return context.WithValue(ctx, budgetKey{}, &stepBudget{limit: steps, parent: current})
+}
+
+// budgetFrom returns the budget of ctx, nil when it has none.
+func budgetFrom(ctx context.Context) *stepBudget {
+	budget, _ := ctx.Value(budgetKey{}).(*stepBudget)
+	return budget
+}
+
+// parseNormalization returns the depth to normalize to for a mode: "whnf"
+// (or "") evaluates to weak head normal form only, depth 0, "nf" to full
+// normal form, depth -1, and "depth" normalizes the top depth levels of
+// applications.
+func parseNormalization(mode string, depth int) (int, error) {
+	switch mode {
+	case "", "whnf":
+		return 0, nil
+	case "nf":
+		return -1, nil
+	case "depth":
+		if depth < 0 {
+			return 0, fmt.Errorf("invalid depth %d", depth)
+		}
+		return depth, nil
+	default:
+		return 0, fmt.Errorf("unknown normalization %q, want whnf, nf or depth", mode)
+	}
+}
+
+// normalize evaluates expr and then the subexpressions of the result, down
+// to depth levels of applications, or all of them when depth is negative.
+// The top level must reach weak head normal form within the budget; below
+// that, running out of budget leaves the remaining subexpressions as they
+// are and reports truncated.
+func normalize(ctx context.Context, expr Expr, symbols map[Symbol]Expr, depth int) (result Expr, truncated bool, err error) {
+	if depth != 0 && budgetFrom(ctx) == nil {
+		ctx = withBudget(ctx, defaultNormalizeBudget)
+	}
+	ev := newEvaluator(ctx, symbols)
+	expr = ev.view(expr).expr
+	defer recoverEval(&err)
+	result = ev.normalize(ev.eval(expr), depth, 0)
+	return result, ev.truncated, nil
+}
+
+// normalizeValue fully normalizes expr under the budget ctx carries, or
+// defaultNormalizeBudget when it carries none, so that it can be printed and
+// converted by its value instead of by how much of it happened to be
+// evaluated. Unlike normalize, it doesn't need any of expr to reduce within
+// the budget: what doesn't, and what isn't data, such as partial
+// applications, stays as it is.
+func normalizeValue(ctx context.Context, expr Expr, symbols map[Symbol]Expr) (result Expr, err error) {
+	if budgetFrom(ctx) == nil {
+		ctx = withBudget(ctx, defaultNormalizeBudget)
+	}
+	ev := newEvaluator(ctx, symbols)
+	defer recoverEval(&err)
+	return ev.normalize(ev.evalWithinBudget(expr), -1, 0), nil
+}
+
+// normalize normalizes the children of an expression already in weak head
+// normal form.
+func (ev *evaluator) normalize(expr Expr, depth, nesting int) Expr {
+	a, ok := expr.(*Ap)
+	if !ok || depth == 0 {
+		return expr
+	}
+	if nesting >= maxNormalizeNesting {
+		ev.truncated = true
+		return expr
+	}
+	left := ev.normalize(ev.evalWithinBudget(a.Left), depth-1, nesting+1)
+	right := ev.normalize(ev.evalWithinBudget(a.Right), depth-1, nesting+1)
+	if left == a.Left && right == a.Right {
+		return a
+	}
+	return ev.ap(left, right)
+}
+
+// evalWithinBudget evaluates expr, or returns it unchanged and marks the
+// normalization truncated once the budget is spent.
+func (ev *evaluator) evalWithinBudget(expr Expr) (result Expr) {
+	if ev.truncated {
+		return resolve(expr)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if aborted, ok := r.(evalAborted); !ok || !errors.Is(aborted.err, errBudgetExhausted) {
+				panic(r)
+			}
+			ev.truncated = true
+			result = resolve(expr)
+		}
+	}()
+	return ev.eval(expr)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	symbols, err := parseProgramText("inc = ap add 1\nf = ap add f\nloop = ap neg loop")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		input    string
+		depth    int
+		expected string
+	}{
+		{"ap cons ap cons ap inc 1", 0, "ap cons ap cons ap inc 1"},
+		{"ap cons ap cons ap inc 1", 1, "ap cons ap cons ap inc 1"},
+		{"ap cons ap cons ap inc 1", 2, "ap cons ap cons 2"},
+		{"ap cons ap cons ap inc 1", -1, "ap cons ap cons 2"},
+		{"ap car ap ap cons ap inc 1 2", -1, "2"},
+		{"inc", 0, "ap add 1"},
+	} {
+		expr, err := parseLine(tt.input)
+		require.NoError(t, err)
+		result, truncated, err := normalize(context.Background(), expr, symbols, tt.depth)
+		require.NoError(t, err, tt.input)
+		assert.False(t, truncated, tt.input)
+		assert.Equal(t, tt.expected, printExpr(result), "%s at depth %d", tt.input, tt.depth)
+	}
+
+	// f unfolds forever: its normal form is cut off by the budget.
+	result, truncated, err := normalize(withBudget(context.Background(), 10), Symbol("f"), symbols, -1)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, "ap add ap add ap add ap add ap add ap add ap add ap add ap add ap add f", printExpr(result))
+
+	// Without even a weak head normal form there is nothing to return.
+	_, _, err = normalize(withBudget(context.Background(), 100), Symbol("loop"), symbols, 0)
+	assert.Equal(t, errBudgetExhausted, err)
+}
+
+func TestParseNormalization(t *testing.T) {
+	for mode, expected := range map[string]int{"": 0, "whnf": 0, "nf": -1, "depth": 3} {
+		depth, err := parseNormalization(mode, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, depth, mode)
+	}
+	_, err := parseNormalization("depth", -1)
+	assert.Error(t, err)
+	_, err = parseNormalization("full", 0)
+	assert.EqualError(t, err, `unknown normalization "full", want whnf, nf or depth`)
+}
+
+func TestEvalNormalizeEndpoint(t *testing.T) {
+	rr := serve(http.MethodPost, "/eval", `{"expression": "ap add ap ap add 1 2", "normalize": "nf"}`)
+	assert.JSONEq(t, `{"result": {"type": "unevaluated", "expr": "ap add 3"}}`, rr.Body.String())
+
+	rr = serve(http.MethodPost, "/eval", `{"expression": "ap add ap ap add 1 2"}`)
+	assert.JSONEq(t, `{"result": {"type": "unevaluated", "expr": "ap add ap ap add 1 2"}}`, rr.Body.String())
+
+	rr = serve(http.MethodPost, "/eval", `{"expression": "ap add ap ap add 1 ap ap add 1 2", "normalize": "nf", "budget": 1}`)
+	var resp EvalResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.True(t, resp.Truncated)
+
+	rr = serve(http.MethodPost, "/eval", `{"expression": "ap ap galaxy nil ap ap cons 0 0", "budget": 5}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.JSONEq(t, `{"error": "reduction budget exhausted"}`, rr.Body.String())
+
+	rr = serve(http.MethodPost, "/eval", `{"expression": "1", "normalize": "full"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openapiSpec describes the HTTP API. TestOpenAPIMatchesHandlers keeps it
+// in sync with routes and the request and response types.
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// openapiHandler serves the OpenAPI document of the HTTP API.
+func openapiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openapiSpec)
+}
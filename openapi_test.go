@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lukehoban/icfp2020/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type openapiDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	OneOf                []*schema          `json:"oneOf"`
+	AdditionalProperties any                `json:"additionalProperties"`
+}
+
+func loadOpenAPI(t *testing.T) openapiDoc {
+	var doc openapiDoc
+	require.NoError(t, json.Unmarshal(openapiSpec, &doc))
+	return doc
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	rr := serve(http.MethodGet, "/openapi.json", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(openapiSpec), rr.Body.String())
+}
+
+// TestOpenAPIMatchesHandlers checks that the document describes every route
+// and exactly the methods its handler accepts.
+func TestOpenAPIMatchesHandlers(t *testing.T) {
+	doc := loadOpenAPI(t)
+	var documented, registered []string
+	for path := range doc.Paths {
+		documented = append(documented, path)
+	}
+	for pattern := range routes {
+		registered = append(registered, pattern)
+	}
+	sort.Strings(documented)
+	sort.Strings(registered)
+	require.Equal(t, registered, documented)
+
+	// Path parameters are filled in with values that exist where it
+	// matters, so that requests get past the lookups to the method checks,
+	// while nothing is changed: uploads are empty and deleting the default
+	// program is refused.
+	fill := strings.NewReplacer("{name}", defaultProgram, "{symbol}", "galaxy", "{hash}", "unknown")
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
+	for _, path := range documented {
+		for _, method := range methods {
+			_, isDocumented := doc.Paths[path][strings.ToLower(method)]
+			rr := serve(method, fill.Replace(path), "")
+			assert.Equal(t, isDocumented, rr.Code != http.StatusMethodNotAllowed, "%s %s answered %d", method, path, rr.Code)
+		}
+	}
+}
+
+// TestOpenAPIMatchesTypes checks that the schemas have the fields of the
+// server's and the client's types, with matching JSON types, and that the
+// fields the server always writes are required.
+func TestOpenAPIMatchesTypes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	types := map[string][2]any{
+		"Value":               {jsonValue{}, client.Value{}},
+		"Point":               {PointPair{}, client.Point{}},
+		"EvalRequest":         {EvalRequest{}, client.EvalRequest{}},
+		"EvalResponse":        {EvalResponse{}, client.EvalResponse{}},
+		"InteractRequest":     {InteractRequest{}, client.InteractRequest{}},
+		"InteractResponse":    {InteractResponse{}, client.InteractResponse{}},
+		"InteractEvent":       {InteractEvent{}, client.InteractEvent{}},
+		"StoredState":         {StoredState{}, client.StoredState{}},
+		"StateResponse":       {StateResponse{}, nil},
+		"ProgramInfo":         {ProgramInfo{}, client.ProgramInfo{}},
+		"ProgramResponse":     {ProgramResponse{}, nil},
+		"ProgramListResponse": {ProgramListResponse{}, nil},
+		"SymbolResponse":      {SymbolResponse{}, client.SymbolResponse{}},
+		"GraphNode":           {GraphNode{}, client.GraphNode{}},
+		"GraphResponse":       {GraphResponse{}, client.GraphResponse{}},
+		"CacheResponse":       {CacheResponse{}, client.CacheResponse{}},
+		"SymbolMemory":        {SymbolMemory{}, client.SymbolMemory{}},
+		"ProgramMemory":       {ProgramMemory{}, client.ProgramMemory{}},
+		"MemoryResponse":      {MemoryResponse{}, client.MemoryResponse{}},
+		"HealthResponse":      {HealthResponse{}, client.HealthResponse{}},
+		"ProgramVersion":      {ProgramVersion{}, client.ProgramVersion{}},
+		"VersionResponse":     {VersionResponse{}, client.VersionResponse{}},
+		"LimitResponse":       {LimitResponse{}, nil},
+	}
+	var names []string
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	for name := range types {
+		assert.Contains(t, names, name, "schema %s is missing", name)
+	}
+	for _, name := range names {
+		pair, ok := types[name]
+		if !assert.True(t, ok, "schema %s has no Go type", name) {
+			continue
+		}
+		for _, v := range pair {
+			if v != nil {
+				c := schemaChecker{t: t, doc: doc, seen: map[string]bool{}}
+				c.check(reflect.TypeOf(v).String(), &schema{Ref: "#/components/schemas/" + name}, reflect.TypeOf(v))
+			}
+		}
+	}
+}
+
+type schemaChecker struct {
+	t    *testing.T
+	doc  openapiDoc
+	seen map[string]bool
+}
+
+var (
+	timeType        = reflect.TypeFor[time.Time]()
+	taggedValueType = reflect.TypeFor[TaggedValue]()
+	rawMessageType  = reflect.TypeFor[json.RawMessage]()
+	numberType      = reflect.TypeFor[json.Number]()
+)
+
+func (c *schemaChecker) check(path string, s *schema, typ reflect.Type) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		resolved, ok := c.doc.Components.Schemas[name]
+		if !assert.True(c.t, ok, "%s: unknown schema %s", path, s.Ref) {
+			return
+		}
+		if typ == taggedValueType {
+			assert.Equal(c.t, "Value", name, "%s: values must refer to the Value schema", path)
+			return
+		}
+		key := name + " " + typ.String()
+		if c.seen[key] {
+			return
+		}
+		c.seen[key] = true
+		s = resolved
+	}
+	switch {
+	case typ == rawMessageType || typ == numberType:
+		// These hold values of any or several JSON types.
+		return
+	case typ == timeType:
+		assert.Equal(c.t, "string", s.Type, path)
+		assert.Equal(c.t, "date-time", s.Format, path)
+		return
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		assert.Equal(c.t, "string", s.Type, path)
+	case reflect.Bool:
+		assert.Equal(c.t, "boolean", s.Type, path)
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		assert.Equal(c.t, "integer", s.Type, path)
+	case reflect.Float64:
+		assert.Equal(c.t, "number", s.Type, path)
+	case reflect.Slice:
+		if assert.Equal(c.t, "array", s.Type, path) && assert.NotNil(c.t, s.Items, path) {
+			c.check(path+"[]", s.Items, typ.Elem())
+		}
+	case reflect.Struct:
+		if !assert.Equal(c.t, "object", s.Type, path) {
+			return
+		}
+		fields := map[string]reflect.StructField{}
+		var required []string
+		jsonFields(typ, fields, &required)
+		var want, got []string
+		for name := range fields {
+			want = append(want, name)
+		}
+		for name := range s.Properties {
+			got = append(got, name)
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+		assert.Equal(c.t, want, got, "%s: properties", path)
+		for name, field := range fields {
+			if prop, ok := s.Properties[name]; ok {
+				c.check(path+"."+name, prop, field.Type)
+			}
+		}
+		for _, name := range s.Required {
+			assert.Contains(c.t, required, name, "%s: %s is required but may be omitted", path, name)
+		}
+	default:
+		c.t.Errorf("%s: unexpected type %s", path, typ)
+	}
+}
+
+// jsonFields collects the fields of a struct by their JSON names,
+// including those of embedded structs, and the names of those that are
+// always encoded.
+func jsonFields(typ reflect.Type, fields map[string]reflect.StructField, required *[]string) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				jsonFields(embedded, fields, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	c := client.New(srv.URL + "/")
+	ctx := context.Background()
+
+	eval, err := c.Eval(ctx, client.EvalRequest{Expression: "ap ap cons 1 ap ap cons 2 nil", Normalize: "nf"})
+	require.NoError(t, err)
+	assert.Equal(t, &client.EvalResponse{Result: &client.Value{Type: "list", Items: []client.Value{client.Int(1), client.Int(2)}}}, eval)
+
+	_, err = c.Eval(ctx, client.EvalRequest{Expression: "ap"})
+	var apiErr *client.Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "Invalid expression", apiErr.Message)
+	assert.NotEmpty(t, apiErr.RequestID)
+
+	state := client.Nil()
+	resp, err := c.Interact(ctx, client.InteractRequest{StateValue: &state})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), resp.Flag)
+	assert.NotEmpty(t, resp.Images)
+	stored, err := c.State(ctx, resp.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, resp.NewState, stored.State)
+
+	var events []string
+	streamed, err := c.InteractStream(ctx, client.InteractRequest{State: "nil"}, func(ev client.InteractEvent) {
+		events = append(events, ev.Type)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, resp.NewState, streamed.NewState)
+	assert.Equal(t, "done", events[len(events)-1])
+
+	t.Cleanup(func() { programs.remove("client") })
+	info, err := c.PutProgram(ctx, "client", "main = ap ap add 1 two\ntwo = 2\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main"}, info.EntryPoints)
+	symbol, err := c.Symbol(ctx, "client", "main")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"two"}, symbol.References)
+	eval, err = c.Eval(ctx, client.EvalRequest{Expression: "main", Program: "client"})
+	require.NoError(t, err)
+	assert.Equal(t, client.Int(3), *eval.Result)
+	list, err := c.Programs(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+	require.NoError(t, c.DeleteProgram(ctx, "client"))
+	_, err = c.Program(ctx, "client")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	health, err := c.Health(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", health.Status)
+	ready, err := c.Ready(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", ready.Status)
+	version, err := c.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, defaultProgram, version.Program.Name)
+	_, err = c.Cache(ctx)
+	require.NoError(t, err)
+	_, err = c.Memory(ctx)
+	require.NoError(t, err)
+}
@@ -0,0 +1,302 @@
+// Package physics implements the rules of the alien game: how ships move
+// around the square planet and how their commands play out. Step is
+// deterministic, so the stand-in server and bots that predict the game
+// agree on every tick.
+package physics
+
+// Vec is a position, velocity, thrust or laser target.
+type Vec struct {
+	X int64 `json:"x"`
+	Y int64 `json:"y"`
+}
+
+// Add returns v+w.
+func (v Vec) Add(w Vec) Vec { return Vec{v.X + w.X, v.Y + w.Y} }
+
+// Sub returns v-w.
+func (v Vec) Sub(w Vec) Vec { return Vec{v.X - w.X, v.Y - w.Y} }
+
+// Params are the resources of a ship. A ship whose Copies drop to zero is
+// destroyed.
+type Params struct {
+	Fuel    int64 `json:"fuel"`
+	Laser   int64 `json:"laser"`
+	Cooling int64 `json:"cooling"`
+	Copies  int64 `json:"copies"`
+}
+
+func (p Params) total() int64 { return p.Fuel + p.Laser + p.Cooling + p.Copies }
+
+// Ship is one ship in a game.
+type Ship struct {
+	Role     int64  `json:"role"`
+	ID       int64  `json:"id"`
+	Position Vec    `json:"position"`
+	Velocity Vec    `json:"velocity"`
+	Params   Params `json:"params"`
+	Heat     int64  `json:"heat"`
+	MaxHeat  int64  `json:"maxHeat"`
+	MaxBoost int64  `json:"maxBoost"`
+}
+
+// Rules are the sizes of the world: ships inside the square of half size
+// PlanetRadius around the origin crash, and ships outside the square of half
+// size SafeRadius are lost. A zero PlanetRadius means there is no planet and
+// no gravity.
+type Rules struct {
+	PlanetRadius int64 `json:"planetRadius"`
+	SafeRadius   int64 `json:"safeRadius"`
+}
+
+// State is the world between two ticks. NextID is the ID the next forked
+// ship gets.
+type State struct {
+	Tick   int64  `json:"tick"`
+	Ships  []Ship `json:"ships"`
+	NextID int64  `json:"nextId"`
+}
+
+// Kind tells commands apart.
+type Kind int
+
+const (
+	Accelerate Kind = iota
+	Detonate
+	Shoot
+	Fork
+)
+
+// Command is an order for one ship. Vector is the thrust of Accelerate,
+// which changes the velocity by minus Vector, and the target of Shoot.
+// Params are what Fork hands to the new ship.
+type Command struct {
+	Kind   Kind   `json:"kind"`
+	ShipID int64  `json:"shipId"`
+	Vector Vec    `json:"vector,omitempty"`
+	Power  int64  `json:"power,omitempty"`
+	Params Params `json:"params,omitempty"`
+}
+
+// Event reports a command that was carried out. Power is the blast of a
+// detonation or the power of a shot, and Damage the heat a shot put into
+// the ships it hit.
+type Event struct {
+	Command
+	Damage int64 `json:"damage,omitempty"`
+}
+
+// Heat and damage constants.
+const (
+	// AccelerateHeat is the heat each unit of thrust makes.
+	AccelerateHeat = 8
+	// ShotRange is how far from its target a shot still hits.
+	ShotRange = 1
+	// DetonationFalloff is how much a blast weakens per unit of distance.
+	DetonationFalloff = 8
+)
+
+// Step plays one tick: commands are carried out in order, then ships fall
+// and move, shots hit, ships cool down and the destroyed ones are removed.
+// Invalid commands, and further commands of a kind a ship already got this
+// tick, are ignored. The returned events list the commands that were
+// carried out in order, followed by the shots. s is not modified.
+func Step(rules Rules, s State, commands []Command) (State, []Event) {
+	next := State{Tick: s.Tick + 1, NextID: s.NextID, Ships: append([]Ship(nil), s.Ships...)}
+	var events []Event
+	var shots []Event
+	destroyed := map[int64]bool{}
+	done := map[[2]int64]bool{}
+
+	for _, cmd := range commands {
+		i := next.find(cmd.ShipID)
+		if i < 0 || destroyed[cmd.ShipID] || done[[2]int64{cmd.ShipID, int64(cmd.Kind)}] {
+			continue
+		}
+		ship := &next.Ships[i]
+		switch cmd.Kind {
+		case Accelerate:
+			thrust := Norm(cmd.Vector)
+			if thrust == 0 || thrust > ship.MaxBoost || thrust > ship.Params.Fuel {
+				continue
+			}
+			ship.Velocity = ship.Velocity.Sub(cmd.Vector)
+			ship.Params.Fuel -= thrust
+			ship.Heat += AccelerateHeat * thrust
+		case Detonate:
+			cmd.Power = BlastPower(ship.Params)
+			for j := range next.Ships {
+				other := &next.Ships[j]
+				if other.ID != ship.ID {
+					other.Heat += max(0, cmd.Power-DetonationFalloff*Distance(ship.Position, other.Position))
+				}
+			}
+			destroyed[ship.ID] = true
+		case Shoot:
+			if cmd.Power <= 0 || cmd.Power > ship.Params.Laser {
+				continue
+			}
+			ship.Heat += cmd.Power
+			shots = append(shots, Event{Command: cmd})
+		case Fork:
+			p := cmd.Params
+			if p.Copies < 1 || p.Fuel < 0 || p.Laser < 0 || p.Cooling < 0 ||
+				p.Fuel > ship.Params.Fuel || p.Laser > ship.Params.Laser ||
+				p.Cooling > ship.Params.Cooling || p.Copies >= ship.Params.Copies {
+				continue
+			}
+			ship.Params = Params{
+				Fuel:    ship.Params.Fuel - p.Fuel,
+				Laser:   ship.Params.Laser - p.Laser,
+				Cooling: ship.Params.Cooling - p.Cooling,
+				Copies:  ship.Params.Copies - p.Copies,
+			}
+			child := *ship
+			child.ID, child.Params, child.Heat = next.NextID, p, 0
+			next.NextID++
+			// Appending may move the ships, so ship must not be used after.
+			next.Ships = append(next.Ships, child)
+		default:
+			continue
+		}
+		done[[2]int64{cmd.ShipID, int64(cmd.Kind)}] = true
+		if cmd.Kind != Shoot {
+			events = append(events, Event{Command: cmd})
+		}
+	}
+
+	for i := range next.Ships {
+		ship := &next.Ships[i]
+		ship.Velocity = ship.Velocity.Add(rules.Gravity(ship.Position))
+		ship.Position = ship.Position.Add(ship.Velocity)
+	}
+
+	for _, shot := range shots {
+		shooter := next.Ships[next.find(shot.ShipID)]
+		shot.Damage = ShotDamage(shooter.Position, shot.Vector, shot.Power)
+		for i := range next.Ships {
+			target := &next.Ships[i]
+			if target.ID != shooter.ID && Distance(target.Position, shot.Vector) <= ShotRange {
+				target.Heat += shot.Damage
+			}
+		}
+		events = append(events, shot)
+	}
+
+	ships := next.Ships[:0]
+	for _, ship := range next.Ships {
+		ship.Heat = max(0, ship.Heat-ship.Params.Cooling)
+		if overflow := ship.Heat - ship.MaxHeat; overflow > 0 {
+			ship.Params = burn(ship.Params, overflow)
+			ship.Heat = ship.MaxHeat
+		}
+		if destroyed[ship.ID] || ship.Params.Copies <= 0 || rules.Crashed(ship.Position) || !rules.Inside(ship.Position) {
+			continue
+		}
+		ships = append(ships, ship)
+	}
+	next.Ships = ships
+	return next, events
+}
+
+func (s *State) find(id int64) int {
+	for i, ship := range s.Ships {
+		if ship.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// burn takes damage out of the fuel, then the laser, the cooling and
+// finally the copies of a ship.
+func burn(p Params, damage int64) Params {
+	for _, v := range []*int64{&p.Fuel, &p.Laser, &p.Cooling, &p.Copies} {
+		taken := min(*v, damage)
+		*v -= taken
+		damage -= taken
+	}
+	return p
+}
+
+// Gravity returns the pull of the square planet on a ship at p: one unit
+// toward the planet along each axis on which p is furthest out, so the
+// diagonals pull on both.
+func (r Rules) Gravity(p Vec) Vec {
+	var g Vec
+	if r.PlanetRadius == 0 {
+		return g
+	}
+	ax, ay := abs(p.X), abs(p.Y)
+	if ax >= ay {
+		g.X = -sign(p.X)
+	}
+	if ay >= ax {
+		g.Y = -sign(p.Y)
+	}
+	return g
+}
+
+// Crashed reports whether p is on or inside the planet.
+func (r Rules) Crashed(p Vec) bool {
+	return r.PlanetRadius > 0 && Norm(p) <= r.PlanetRadius
+}
+
+// Inside reports whether p is within the safe zone.
+func (r Rules) Inside(p Vec) bool {
+	return Norm(p) <= r.SafeRadius
+}
+
+// Survival returns for how many of the next ticks, up to horizon, a ship
+// that accelerates by thrust now and then drifts stays clear of the planet
+// and within the safe zone.
+func (r Rules) Survival(ship Ship, thrust Vec, horizon int) int {
+	pos, vel := ship.Position, ship.Velocity.Sub(thrust)
+	for tick := 0; tick < horizon; tick++ {
+		vel = vel.Add(r.Gravity(pos))
+		pos = pos.Add(vel)
+		if r.Crashed(pos) || !r.Inside(pos) {
+			return tick
+		}
+	}
+	return horizon
+}
+
+// BlastPower is the damage a detonating ship with params does at distance
+// zero.
+func BlastPower(p Params) int64 {
+	return 64 + p.total()/4
+}
+
+// ShotDamage is the heat a shot of power fired from p puts into the ships
+// around target: three times the power, less the distance it travels.
+func ShotDamage(p, target Vec, power int64) int64 {
+	return max(0, 3*power-Distance(p, target))
+}
+
+// Norm is the Chebyshev length of v, which the game measures distances,
+// thrusts and the planet with.
+func Norm(v Vec) int64 {
+	return max(abs(v.X), abs(v.Y))
+}
+
+// Distance is the Chebyshev distance between a and b.
+func Distance(a, b Vec) int64 {
+	return Norm(a.Sub(b))
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int64) int64 {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package physics
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// scenario is a test case in testdata: a world and the commands for each
+// of its ticks. Its golden file holds the state and events after each tick.
+type scenario struct {
+	Comment string      `json:"comment"`
+	Rules   Rules       `json:"rules"`
+	State   State       `json:"state"`
+	Ticks   [][]Command `json:"ticks"`
+}
+
+type tickResult struct {
+	State  State   `json:"state"`
+	Events []Event `json:"events"`
+}
+
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			byts, err := os.ReadFile(file)
+			require.NoError(t, err)
+			var sc scenario
+			require.NoError(t, json.Unmarshal(byts, &sc))
+
+			var results []tickResult
+			state := sc.State
+			for _, commands := range sc.Ticks {
+				var events []Event
+				state, events = Step(sc.Rules, state, commands)
+				results = append(results, tickResult{state, events})
+			}
+			got, err := json.MarshalIndent(results, "", "  ")
+			require.NoError(t, err)
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(file, ".json") + ".golden"
+			if *update {
+				require.NoError(t, os.WriteFile(golden, got, 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+}
+
+func TestStepIsDeterministicAndPure(t *testing.T) {
+	rules := Rules{PlanetRadius: 16, SafeRadius: 128}
+	state := State{Ships: []Ship{
+		{ID: 0, Position: Vec{48, 0}, Params: Params{Fuel: 10, Laser: 10, Copies: 2}, MaxHeat: 64, MaxBoost: 2},
+		{ID: 1, Role: 1, Position: Vec{-48, 0}, Params: Params{Fuel: 10, Copies: 1}, MaxHeat: 64, MaxBoost: 2},
+	}, NextID: 2}
+	before, err := json.Marshal(state)
+	require.NoError(t, err)
+	commands := []Command{
+		{Kind: Fork, ShipID: 0, Params: Params{Fuel: 5, Copies: 1}},
+		{Kind: Accelerate, ShipID: 0, Vector: Vec{0, 1}},
+		{Kind: Shoot, ShipID: 0, Vector: Vec{-47, 0}, Power: 10},
+	}
+	first, firstEvents := Step(rules, state, commands)
+	second, secondEvents := Step(rules, state, commands)
+	assert.Equal(t, first, second)
+	assert.Equal(t, firstEvents, secondEvents)
+
+	after, err := json.Marshal(state)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(before, after), "Step modified its input")
+}
+
+func TestSurvival(t *testing.T) {
+	rules := Rules{PlanetRadius: 16, SafeRadius: 128}
+	assert.Equal(t, 7, rules.Survival(Ship{Position: Vec{48, 48}}, Vec{}, 32))
+	assert.Equal(t, 0, rules.Survival(Ship{Position: Vec{120, 0}, Velocity: Vec{10, 0}}, Vec{}, 32))
+	assert.Equal(t, 32, rules.Survival(Ship{Position: Vec{48, 48}, Velocity: Vec{6, -6}}, Vec{}, 32))
+}
+
+func TestBurn(t *testing.T) {
+	assert.Equal(t, Params{Fuel: 0, Laser: 3, Cooling: 2, Copies: 1}, burn(Params{Fuel: 4, Laser: 5, Cooling: 2, Copies: 1}, 6))
+	assert.Equal(t, Params{}, burn(Params{Fuel: 1, Copies: 1}, 100))
+}
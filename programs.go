@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultProgram is the name of the program used when a request or command
+// doesn't select one.
+const defaultProgram = "galaxy"
+
+// maxUploadedPrograms bounds how many programs without a backing file can
+// be registered at once.
+const maxUploadedPrograms = 64
+
+// Errors of uploading and deleting programs.
+var (
+	errProgramFromFile = errors.New("program loaded from a file")
+	errTooManyPrograms = errors.New("too many uploaded programs")
+	errUnknownProgram  = errors.New("unknown program")
+)
+
+// program is a parsed program file. Its symbol table is never changed once
+// it is registered: reloading parses a fresh one and swaps it in, so
+// requests that already hold the old one finish against it. Its application
+// nodes are shared by all of those requests, though, and are not read-only:
+// every evaluation writes the values it computes into their Ap.v caches.
+type program struct {
+	name     string
+	path     string
+	symbols  map[Symbol]Expr
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+	// hash is the SHA-256 of the program's source: the file it was read
+	// from or the text it was uploaded as.
+	hash string
+
+	graphOnce sync.Once
+	graph     *depGraph
+
+	// interner hash-conses the program and the expressions evaluated
+	// against it, when the registry interns.
+	interner *interner
+
+	// generation counts the flushes of the evaluation cache.
+	generation atomic.Int64
+}
+
+// programRegistry holds the named programs the server can evaluate against.
+type programRegistry struct {
+	mu       sync.RWMutex
+	programs map[string]*program
+
+	// intern makes programs loaded from now on hash-cons their expressions.
+	intern bool
+}
+
+var programs = newProgramRegistry()
+
+func newProgramRegistry() *programRegistry {
+	return &programRegistry{programs: map[string]*program{}}
+}
+
+// add registers already parsed symbols that have no backing file, such
+// as an uploaded program.
+func (r *programRegistry) add(name string, symbols map[Symbol]Expr) *program {
+	p := r.newProgram(name, symbols)
+	r.mu.Lock()
+	r.programs[name] = p
+	r.mu.Unlock()
+	return p
+}
+
+// upload registers an uploaded program under name. It fails with
+// errProgramFromFile when a program loaded from a file has that name, since
+// replacing it would stop its reloads, and with errTooManyPrograms when it
+// would register more than maxUploadedPrograms.
+func (r *programRegistry) upload(name string, source []byte, symbols map[Symbol]Expr) (*program, error) {
+	p := r.newProgram(name, symbols)
+	p.hash = sourceHash(source)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, replacing := r.programs[name]
+	if replacing && old.path != "" {
+		return nil, errProgramFromFile
+	}
+	if !replacing {
+		uploaded := 0
+		for _, p := range r.programs {
+			if p.path == "" {
+				uploaded++
+			}
+		}
+		if uploaded >= maxUploadedPrograms {
+			return nil, errTooManyPrograms
+		}
+	}
+	r.programs[name] = p
+	return p, nil
+}
+
+// discard unregisters an uploaded program. It fails with errUnknownProgram
+// when there is none by that name and with errProgramFromFile, like upload,
+// when the program was loaded from a file.
+func (r *programRegistry) discard(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.programs[name]
+	if !ok {
+		return errUnknownProgram
+	}
+	if p.path != "" {
+		return errProgramFromFile
+	}
+	delete(r.programs, name)
+	return nil
+}
+
+// remove unregisters a program, reporting whether it existed.
+func (r *programRegistry) remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.programs[name]
+	delete(r.programs, name)
+	return ok
+}
+
+func (r *programRegistry) newProgram(name string, symbols map[Symbol]Expr) *program {
+	p := &program{name: name, symbols: symbols, loadedAt: time.Now()}
+	if r.intern {
+		p.interner = newInterner()
+		for s, expr := range symbols {
+			symbols[s] = p.interner.intern(expr)
+		}
+	}
+	return p
+}
+
+func (r *programRegistry) read(name, path string) (*program, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := parseProgramText(string(source))
+	if err != nil {
+		return nil, err
+	}
+	p := r.newProgram(name, symbols)
+	p.path = path
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	p.hash = sourceHash(source)
+	return p, nil
+}
+
+// sourceHash returns the hex SHA-256 of program source.
+func sourceHash(source []byte) string {
+	sum := sha256.Sum256(source)
+	return hex.EncodeToString(sum[:])
+}
+
+// load parses the program at path and registers it under name, replacing
+// any program previously registered with that name.
+func (r *programRegistry) load(name, path string) (*program, error) {
+	p, err := r.read(name, path)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.programs[name] = p
+	r.mu.Unlock()
+	return p, nil
+}
+
+func (r *programRegistry) get(name string) (*program, bool) {
+	if name == "" {
+		name = defaultProgram
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.programs[name]
+	return p, ok
+}
+
+// list returns the registered programs sorted by name.
+func (r *programRegistry) list() []*program {
+	r.mu.RLock()
+	result := make([]*program, 0, len(r.programs))
+	for _, p := range r.programs {
+		result = append(result, p)
+	}
+	r.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result
+}
+
+// reloadChanged re-parses every program whose file changed on disk since it
+// was loaded. A program that fails to parse keeps serving its last good
+// version. It returns the names of the programs that were swapped.
+func (r *programRegistry) reloadChanged() []string {
+	var reloaded []string
+	for _, old := range r.list() {
+		if old.path == "" {
+			continue
+		}
+		info, err := os.Stat(old.path)
+		if err != nil || (info.ModTime().Equal(old.modTime) && info.Size() == old.size) {
+			continue
+		}
+		p, err := r.read(old.name, old.path)
+		if err != nil {
+			slog.Error("program reload failed", "program", old.name, "err", err)
+			continue
+		}
+		r.mu.Lock()
+		// Only swap if nobody replaced the program in the meantime.
+		if r.programs[old.name] == old {
+			r.programs[old.name] = p
+			reloaded = append(reloaded, old.name)
+		}
+		r.mu.Unlock()
+	}
+	return reloaded
+}
+
+// watch polls the program files every interval until ctx is done.
+func (r *programRegistry) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, name := range r.reloadChanged() {
+				slog.Info("reloaded program", "program", name)
+			}
+		}
+	}
+}
+
+// references returns the symbols an expression mentions, each once, in the
+// order they first appear.
+func references(expr Expr) []Symbol {
+	var result []Symbol
+	seen := map[Symbol]bool{}
+	var walk func(Expr)
+	walk = func(expr Expr) {
+		switch e := expr.(type) {
+		case Symbol:
+			if !seen[e] {
+				seen[e] = true
+				result = append(result, e)
+			}
+		case *Ap:
+			walk(e.Left)
+			walk(e.Right)
+		}
+	}
+	walk(expr)
+	return result
+}
+
+// entryPoints returns, sorted, the symbols of a program that no other
+// definition refers to.
+func (p *program) entryPoints() []Symbol {
+	referenced := map[Symbol]bool{}
+	for name, expr := range p.symbols {
+		for _, s := range references(expr) {
+			if s != name {
+				referenced[s] = true
+			}
+		}
+	}
+	var result []Symbol
+	for name := range p.symbols {
+		if !referenced[name] {
+			result = append(result, name)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// context attaches the program's evaluation settings to ctx.
+func (p *program) context(ctx context.Context) context.Context {
+	return withInterner(ctx, p.interner)
+}
+
+// dependencyGraph returns the program's dependency graph, building it on
+// first use.
+func (p *program) dependencyGraph() *depGraph {
+	p.graphOnce.Do(func() { p.graph = buildGraph(p.symbols) })
+	return p.graph
+}
+
+// defaultSymbols returns the symbol table of the default program.
+func defaultSymbols() map[Symbol]Expr {
+	if p, ok := programs.get(defaultProgram); ok {
+		return p.symbols
+	}
+	return nil
+}
+
+// programFlag collects repeated -load name=path flags.
+type programFlag map[string]string
+
+func (pf programFlag) String() string {
+	var pieces []string
+	for name, path := range pf {
+		pieces = append(pieces, name+"="+path)
+	}
+	sort.Strings(pieces)
+	return strings.Join(pieces, ",")
+}
+
+func (pf programFlag) Set(value string) error {
+	name, path, ok := strings.Cut(value, "=")
+	if !ok || name == "" || path == "" {
+		return fmt.Errorf("expected name=path, got %q", value)
+	}
+	pf[name] = path
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxProgramSize bounds the size of an uploaded program.
+const maxProgramSize = 16 << 20
+
+type ProgramInfo struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path,omitempty"`
+	Symbols     int       `json:"symbols"`
+	EntryPoints []string  `json:"entryPoints"`
+	LoadedAt    time.Time `json:"loadedAt"`
+}
+
+type ProgramResponse struct {
+	Program *ProgramInfo `json:"program,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type ProgramListResponse struct {
+	Programs []ProgramInfo `json:"programs"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type SymbolResponse struct {
+	Program      string   `json:"program"`
+	Name         string   `json:"name"`
+	Definition   string   `json:"definition"`
+	References   []string `json:"references"`
+	ReferencedBy []string `json:"referencedBy"`
+	Error        string   `json:"error,omitempty"`
+}
+
+type GraphNode struct {
+	Name       string   `json:"name"`
+	References []string `json:"references"`
+}
+
+type GraphResponse struct {
+	Program    string      `json:"program"`
+	Nodes      []GraphNode `json:"nodes"`
+	Components [][]string  `json:"components"`
+	Error      string      `json:"error,omitempty"`
+}
+
+func (p *program) info() ProgramInfo {
+	return ProgramInfo{
+		Name:        p.name,
+		Path:        p.path,
+		Symbols:     len(p.symbols),
+		EntryPoints: symbolStrings(p.entryPoints()),
+		LoadedAt:    p.loadedAt,
+	}
+}
+
+// programsHandler lists the loaded programs.
+func programsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ProgramListResponse{Error: "Method not allowed"})
+		return
+	}
+
+	resp := ProgramListResponse{Programs: []ProgramInfo{}}
+	for _, p := range programs.list() {
+		resp.Programs = append(resp.Programs, p.info())
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// programHandler fetches (GET), uploads (PUT or POST) or deletes (DELETE)
+// the program named in the path. Uploads are program text in the
+// galaxy.txt format and replace any uploaded program with the same name;
+// programs loaded from files, such as the default one, can't be replaced
+// or deleted.
+func programHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		p, ok := programs.get(name)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ProgramResponse{Error: "Unknown program"})
+			return
+		}
+		info := p.info()
+		json.NewEncoder(w).Encode(ProgramResponse{Program: &info})
+
+	case http.MethodPut, http.MethodPost:
+		byts, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProgramSize))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				json.NewEncoder(w).Encode(ProgramResponse{Error: "Program too large"})
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ProgramResponse{Error: "Failed to read program"})
+			return
+		}
+		symbols, err := parseProgramText(string(byts))
+		if err == nil && len(symbols) == 0 {
+			err = errors.New("program has no definitions")
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ProgramResponse{Error: "Invalid program: " + err.Error()})
+			return
+		}
+		p, err := programs.upload(name, byts, symbols)
+		if err != nil {
+			msg := "Programs loaded from files cannot be replaced"
+			if errors.Is(err, errTooManyPrograms) {
+				msg = "Too many programs uploaded"
+			}
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(ProgramResponse{Error: msg})
+			return
+		}
+		info := p.info()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ProgramResponse{Program: &info})
+
+	case http.MethodDelete:
+		if name == defaultProgram {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(ProgramResponse{Error: "The default program cannot be deleted"})
+			return
+		}
+		switch err := programs.discard(name); {
+		case errors.Is(err, errUnknownProgram):
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ProgramResponse{Error: "Unknown program"})
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(ProgramResponse{Error: "Programs loaded from files cannot be deleted"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ProgramResponse{Error: "Method not allowed"})
+	}
+}
+
+// symbolHandler returns the definition of one symbol of a program.
+func symbolHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(SymbolResponse{Error: "Method not allowed"})
+		return
+	}
+
+	p, ok := programs.get(r.PathValue("name"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(SymbolResponse{Error: "Unknown program"})
+		return
+	}
+	name := r.PathValue("symbol")
+	expr, ok := p.symbols[Symbol(name)]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(SymbolResponse{Program: p.name, Name: name, Error: "Unknown symbol"})
+		return
+	}
+	g := p.dependencyGraph()
+	json.NewEncoder(w).Encode(SymbolResponse{
+		Program:      p.name,
+		Name:         name,
+		Definition:   printExpr(expr),
+		References:   symbolStrings(g.edges[Symbol(name)]),
+		ReferencedBy: symbolStrings(g.reverse[Symbol(name)]),
+	})
+}
+
+// graphHandler exports the dependency graph of a program as JSON or, with
+// ?format=dot, in Graphviz format.
+func graphHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(GraphResponse{Error: "Method not allowed"})
+		return
+	}
+
+	p, ok := programs.get(r.PathValue("name"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(GraphResponse{Error: "Unknown program"})
+		return
+	}
+	g := p.dependencyGraph()
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		resp := GraphResponse{Program: p.name, Nodes: []GraphNode{}, Components: [][]string{}}
+		for _, s := range g.nodes {
+			resp.Nodes = append(resp.Nodes, GraphNode{Name: string(s), References: symbolStrings(g.edges[s])})
+		}
+		for _, component := range g.components() {
+			resp.Components = append(resp.Components, symbolStrings(component))
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write([]byte(g.dot(p.name)))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(GraphResponse{Error: "Unknown format"})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(method, target, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	newMux().ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rr
+}
+
+func TestProgramUploadLifecycle(t *testing.T) {
+	t.Cleanup(func() { programs.remove("pwr") })
+
+	rr := serve(http.MethodPut, "/programs/pwr", "pwr2 = ap ap s ap ap c ap eq 0 1 ap ap b ap mul 2 ap ap b pwr2 ap add -1\nmain = ap pwr2 5\n")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created ProgramResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "pwr", created.Program.Name)
+	assert.Equal(t, 2, created.Program.Symbols)
+	assert.Equal(t, []string{"main"}, created.Program.EntryPoints)
+
+	rr = serve(http.MethodPost, "/eval", `{"expression": "main", "program": "pwr"}`)
+	assert.JSONEq(t, `{"result": {"type": "int", "value": 32}}`, rr.Body.String())
+
+	rr = serve(http.MethodGet, "/programs", "")
+	var list ProgramListResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	var names []string
+	for _, p := range list.Programs {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"galaxy", "pwr"}, names)
+	assert.Equal(t, 393, list.Programs[0].Symbols)
+	assert.Equal(t, "galaxy.txt", list.Programs[0].Path)
+
+	rr = serve(http.MethodGet, "/programs/pwr/symbols/main", "")
+	assert.JSONEq(t, `{"program": "pwr", "name": "main", "definition": "ap pwr2 5", "references": ["pwr2"], "referencedBy": []}`, rr.Body.String())
+
+	rr = serve(http.MethodDelete, "/programs/pwr", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = serve(http.MethodGet, "/programs/pwr", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestProgramUploadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedError  string
+	}{
+		{"malformed line", http.MethodPut, "/programs/bad", "main ap add 1\n", 400, "Invalid program: invalid line: main ap add 1"},
+		{"incomplete expression", http.MethodPut, "/programs/bad", "main = ap add\n", 400, "Invalid program: line 1: invalid expression for main"},
+		{"empty program", http.MethodPut, "/programs/bad", "\n", 400, "Invalid program: program has no definitions"},
+		{"replace default", http.MethodPut, "/programs/galaxy", "main = 1\n", 409, "Programs loaded from files cannot be replaced"},
+		{"delete default", http.MethodDelete, "/programs/galaxy", "", 409, "The default program cannot be deleted"},
+		{"delete missing", http.MethodDelete, "/programs/missing", "", 404, "Unknown program"},
+		{"unknown symbol", http.MethodGet, "/programs/galaxy/symbols/missing", "", 404, "Unknown symbol"},
+		{"list with POST", http.MethodPost, "/programs", "", 405, "Method not allowed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(tt.method, tt.target, tt.body)
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			var resp struct{ Error string }
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			assert.Equal(t, tt.expectedError, resp.Error)
+		})
+	}
+	_, ok := programs.get("bad")
+	assert.False(t, ok)
+	p, ok := programs.get(defaultProgram)
+	require.True(t, ok)
+	assert.Equal(t, "galaxy.txt", p.path)
+}
+
+func TestProgramFromFileCannotBeDeleted(t *testing.T) {
+	_, err := programs.load("loaded", "galaxy.txt")
+	require.NoError(t, err)
+	t.Cleanup(func() { programs.remove("loaded") })
+
+	rr := serve(http.MethodDelete, "/programs/loaded", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.JSONEq(t, `{"error": "Programs loaded from files cannot be deleted"}`, rr.Body.String())
+	p, ok := programs.get("loaded")
+	require.True(t, ok)
+	assert.Equal(t, "galaxy.txt", p.path)
+}
+
+func TestProgramUploadLimit(t *testing.T) {
+	uploaded := 0
+	for _, p := range programs.list() {
+		if p.path == "" {
+			uploaded++
+		}
+	}
+	for i := uploaded; i < maxUploadedPrograms; i++ {
+		name := fmt.Sprintf("upload%d", i)
+		rr := serve(http.MethodPut, "/programs/"+name, "main = 1\n")
+		require.Equal(t, http.StatusCreated, rr.Code)
+		t.Cleanup(func() { programs.remove(name) })
+	}
+
+	rr := serve(http.MethodPut, "/programs/onetoomany", "main = 1\n")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.JSONEq(t, `{"error": "Too many programs uploaded"}`, rr.Body.String())
+	// Uploaded programs can still be replaced.
+	rr = serve(http.MethodPut, fmt.Sprintf("/programs/upload%d", maxUploadedPrograms-1), "main = 2\n")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+}
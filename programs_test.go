@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgramRegistryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answer.txt")
+	require.NoError(t, os.WriteFile(path, []byte("answer = 41\n"), 0o644))
+
+	r := newProgramRegistry()
+	old, err := r.load("answer", path)
+	require.NoError(t, err)
+	assert.Empty(t, r.reloadChanged())
+
+	// A broken edit keeps the last good version.
+	require.NoError(t, os.WriteFile(path, []byte("answer = ap add\n"), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), old.modTime.Add(time.Second)))
+	assert.Empty(t, r.reloadChanged())
+	current, _ := r.get("answer")
+	assert.Same(t, old, current)
+
+	require.NoError(t, os.WriteFile(path, []byte("answer = 42\n"), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), old.modTime.Add(2*time.Second)))
+	assert.Equal(t, []string{"answer"}, r.reloadChanged())
+
+	current, _ = r.get("answer")
+	assert.Equal(t, Number(42), current.symbols["answer"])
+	// Holders of the previous version still see it unchanged.
+	assert.Equal(t, Number(41), old.symbols["answer"])
+}
+
+func TestEvalSelectsProgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answer.txt")
+	require.NoError(t, os.WriteFile(path, []byte("answer = 42\n"), 0o644))
+	_, err := programs.load("answer", path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		programs.mu.Lock()
+		delete(programs.programs, "answer")
+		programs.mu.Unlock()
+	})
+
+	for _, tt := range []struct {
+		program        string
+		expectedStatus int
+		expected       EvalResponse
+	}{
+		{"answer", 200, EvalResponse{Result: &TaggedValue{Int(42)}}},
+		{"", 200, EvalResponse{Result: &TaggedValue{Unevaluated{Symbol("answer")}}}},
+		{"missing", 404, EvalResponse{Error: "Unknown program"}},
+	} {
+		body, _ := json.Marshal(EvalRequest{Expression: "answer", Program: tt.program})
+		rr := httptest.NewRecorder()
+		evalHandler(rr, httptest.NewRequest(http.MethodPost, "/eval", bytes.NewBuffer(body)))
+
+		assert.Equal(t, tt.expectedStatus, rr.Code)
+		var resp EvalResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, tt.expected, resp)
+	}
+}
+
+func TestProgramFlag(t *testing.T) {
+	pf := programFlag{}
+	assert.NoError(t, pf.Set("patched=galaxy-patched.txt"))
+	assert.Error(t, pf.Set("patched"))
+	assert.Equal(t, "patched=galaxy-patched.txt", pf.String())
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+const replHelp = `Enter a galaxy expression to evaluate it, or one of:
+  name = expr     define (or redefine) a symbol
+  :load path      load definitions from a program file such as galaxy.txt
+  :type expr      show how the result is interpreted as a value
+  :value expr     show the result converted to a value
+  :trace          toggle printing of every reduction step
+  :history        list previous inputs
+  :help           show this message
+  :quit           exit
+Each line is limited to -budget reduction steps, and an interrupt (Ctrl-C)
+abandons the running evaluation. Input is read line by line, so the REPL
+works well under rlwrap.`
+
+// maxHistory bounds the number of lines kept in the history file.
+const maxHistory = 1000
+
+// defaultREPLBudget bounds the reduction steps of each line of a session,
+// so that an expression that never reaches weak head normal form reports an
+// error instead of hanging.
+const defaultREPLBudget = 100_000_000
+
+// repl is an interactive session over a private copy of a symbol table.
+// The copy has its own application nodes, so the values eval caches in them
+// are the session's own and can be dropped when a definition changes.
+type repl struct {
+	symbols map[Symbol]Expr
+	trace   bool
+	out     io.Writer
+	// budget bounds the reduction steps of each line, 0 for no limit.
+	budget int64
+
+	history     []string
+	historyPath string
+}
+
+func newREPL(symbols map[Symbol]Expr, out io.Writer, historyPath string) *repl {
+	r := &repl{
+		symbols:     copyDefinitions(symbols),
+		out:         out,
+		budget:      defaultREPLBudget,
+		historyPath: historyPath,
+	}
+	r.loadHistory()
+	return r
+}
+
+// defaultHistoryPath returns $GALAXY_HISTORY, or ~/.galaxy_history.
+func defaultHistoryPath() string {
+	if path := os.Getenv("GALAXY_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".galaxy_history")
+}
+
+func (r *repl) loadHistory() {
+	if r.historyPath == "" {
+		return
+	}
+	byts, err := os.ReadFile(r.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(byts), "\n") {
+		if line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+}
+
+func (r *repl) saveHistory() error {
+	if r.historyPath == "" {
+		return nil
+	}
+	return os.WriteFile(r.historyPath, []byte(strings.Join(r.history, "\n")+"\n"), 0o600)
+}
+
+// run reads lines from in until EOF or :quit. The prompt is only printed
+// when prompt is true, so piped input produces clean output.
+func (r *repl) run(in io.Reader, prompt bool) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for {
+		if prompt {
+			fmt.Fprint(r.out, "galaxy> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		r.history = append(r.history, line)
+		if line == ":quit" || line == ":q" {
+			break
+		}
+		r.execute(line)
+	}
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+	if err := r.saveHistory(); err != nil {
+		return err
+	}
+	return scanner.Err()
+}
+
+func (r *repl) execute(line string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			fmt.Fprintf(r.out, "error: evaluation failed: %v\n", rec)
+		}
+	}()
+
+	if strings.HasPrefix(line, ":") {
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case ":help", ":h":
+			fmt.Fprintln(r.out, replHelp)
+		case ":load":
+			r.load(arg)
+		case ":type":
+			if result, ok := r.evalLine(arg); ok {
+				fmt.Fprintln(r.out, describeValue(result))
+			}
+		case ":value":
+			if result, ok := r.evalLine(arg); ok {
+				fmt.Fprintln(r.out, formatValue(result))
+			}
+		case ":trace":
+			switch arg {
+			case "on":
+				r.trace = true
+			case "off":
+				r.trace = false
+			default:
+				r.trace = !r.trace
+			}
+			fmt.Fprintf(r.out, "trace %s\n", map[bool]string{true: "on", false: "off"}[r.trace])
+		case ":history":
+			for i, entry := range r.history {
+				fmt.Fprintf(r.out, "%4d  %s\n", i+1, entry)
+			}
+		default:
+			fmt.Fprintf(r.out, "error: unknown command %s (try :help)\n", cmd)
+		}
+		return
+	}
+
+	if name, body, ok := strings.Cut(line, " = "); ok && !strings.Contains(strings.TrimSpace(name), " ") {
+		expr, err := parseLine(body)
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+			return
+		}
+		r.define(map[Symbol]Expr{Symbol(strings.TrimSpace(name)): expr})
+		fmt.Fprintf(r.out, "%s defined\n", strings.TrimSpace(name))
+		return
+	}
+
+	if result, ok := r.evalLine(line); ok {
+		fmt.Fprintln(r.out, printExpr(result))
+	}
+}
+
+func (r *repl) load(path string) {
+	if path == "" {
+		fmt.Fprintln(r.out, "error: usage: :load path")
+		return
+	}
+	program, err := parseProgram(path)
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+	r.define(program)
+	fmt.Fprintf(r.out, "loaded %d symbols from %s\n", len(program), path)
+}
+
+// define adds or replaces definitions. Values cached for the old
+// definitions may be wrong for the new ones, in the symbols that depend on
+// them too, so all cached values are dropped. The nodes are the session's
+// own, so they are cleared in place.
+func (r *repl) define(symbols map[Symbol]Expr) {
+	for name, expr := range symbols {
+		r.symbols[name] = expr
+	}
+	seen := map[*Ap]bool{}
+	stack := make([]Expr, 0, len(r.symbols))
+	for _, expr := range r.symbols {
+		stack = append(stack, expr)
+	}
+	for len(stack) > 0 {
+		a, ok := stack[len(stack)-1].(*Ap)
+		stack = stack[:len(stack)-1]
+		if !ok || seen[a] {
+			continue
+		}
+		seen[a] = true
+		a.v = nil
+		stack = append(stack, a.Left, a.Right)
+	}
+}
+
+// evalLine evaluates a line within the session's budget. An interrupt
+// abandons the evaluation instead of ending the session.
+func (r *repl) evalLine(line string) (Expr, bool) {
+	expr, err := parseLine(line)
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return nil, false
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ev := newEvaluator(withBudget(ctx, r.budget), r.symbols)
+	if r.trace {
+		ev.trace = func(depth int, from, to Expr) {
+			fmt.Fprintf(r.out, "%s%s => %s\n", strings.Repeat("  ", depth-1), truncate(printExpr(from), 120), truncate(printExpr(to), 120))
+		}
+	}
+	result, err := ev.evalSafe(expr)
+	if errors.Is(err, context.Canceled) {
+		err = errors.New("interrupted")
+	}
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return nil, false
+	}
+	return result, true
+}
+
+// parseLine parses a single expression that must consume the whole line.
+func parseLine(line string) (Expr, error) {
+	tokens := strings.Fields(line)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	expr, remaining := parseExpr(tokens)
+	if expr == nil || len(remaining) > 0 {
+		return nil, fmt.Errorf("invalid expression")
+	}
+	return expr, nil
+}
+
+// describeValue reports how toValue interprets an evaluated expression.
+func describeValue(expr Expr) string {
+	v := toValue(expr)
+	if !isData(v) {
+		return "unevaluated"
+	}
+	switch v := v.(type) {
+	case Int:
+		return "number"
+	case Nil:
+		return "nil"
+	case List:
+		return fmt.Sprintf("list of %d", len(v))
+	default:
+		return "pair"
+	}
+}
+
+// formatValue prints the toValue interpretation of expr, falling back to the
+// expression itself when it is not a value.
+func formatValue(expr Expr) string {
+	v := toValue(expr)
+	if !isData(v) {
+		return "unevaluated: " + printExpr(expr)
+	}
+	return v.String()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// isTerminal reports whether f is attached to a character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func runREPL(args []string) error {
+	var cfg config
+	fs := newFlagSet("repl", &cfg)
+	historyPath := fs.String("history", defaultHistoryPath(), "file that keeps input history between sessions (GALAXY_HISTORY)")
+	budget := fs.Int64("budget", defaultREPLBudget, "maximum number of reduction steps of each line, 0 for no limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	r := newREPL(defaultSymbols(), os.Stdout, *historyPath)
+	r.budget = *budget
+	for _, path := range fs.Args() {
+		r.load(path)
+	}
+	return r.run(os.Stdin, isTerminal(os.Stdin))
+}
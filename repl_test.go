@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestREPL(t *testing.T) {
+	historyPath := filepath.Join(t.TempDir(), "history")
+	var out bytes.Buffer
+	r := newREPL(map[Symbol]Expr{}, &out, historyPath)
+
+	input := strings.Join([]string{
+		"ap ap add 1 2",
+		"inc = ap add 1",
+		"ap inc 41",
+		":type ap ap cons 1 nil",
+		":value ap ap cons 1 ap ap cons 2 nil",
+		":type ap add 1",
+		":value ap ap cons 1 2",
+		"ap ap",
+		":bogus",
+	}, "\n")
+	assert.NoError(t, r.run(strings.NewReader(input), false))
+
+	assert.Equal(t, strings.Join([]string{
+		"3",
+		"inc defined",
+		"42",
+		"list of 1",
+		"[1 2]",
+		"unevaluated",
+		"{1 2}",
+		"error: invalid expression",
+		"error: unknown command :bogus (try :help)",
+		"",
+	}, "\n"), out.String())
+
+	// History persists into the next session.
+	out.Reset()
+	r2 := newREPL(map[Symbol]Expr{}, &out, historyPath)
+	assert.NoError(t, r2.run(strings.NewReader(":history\n"), false))
+	assert.Contains(t, out.String(), "   2  inc = ap add 1")
+	assert.Contains(t, out.String(), "  10  :history")
+}
+
+func TestREPLLoadAndTrace(t *testing.T) {
+	var out bytes.Buffer
+	r := newREPL(map[Symbol]Expr{}, &out, "")
+	assert.NoError(t, r.run(strings.NewReader(":load galaxy.txt\n:trace\nap ap t 1 2\n:trace off\n"), false))
+
+	assert.Contains(t, out.String(), "loaded 393 symbols from galaxy.txt")
+	assert.Contains(t, out.String(), "trace on\nap ap t 1 2 => 1\n1\ntrace off\n")
+	assert.Contains(t, r.symbols, Symbol("galaxy"))
+}
+
+func TestREPLRedefinition(t *testing.T) {
+	var out bytes.Buffer
+	r := newREPL(map[Symbol]Expr{}, &out, "")
+	input := "x = 1\ny = ap ap add x 1\nz = ap neg ap i y\nz\nx = 5\nz\n"
+	assert.NoError(t, r.run(strings.NewReader(input), false))
+	assert.Equal(t, "x defined\ny defined\nz defined\n-2\nx defined\n-6\n", out.String())
+
+	// The session caches values in its own copy of the symbols.
+	inner := &Ap{Left: Symbol("i"), Right: Number(1)}
+	symbols := map[Symbol]Expr{"w": &Ap{Left: Symbol("neg"), Right: inner}}
+	out.Reset()
+	r = newREPL(symbols, &out, "")
+	assert.NoError(t, r.run(strings.NewReader("w\n"), false))
+	assert.Equal(t, "-1\n", out.String())
+	assert.Nil(t, inner.v)
+}
+
+func TestREPLBudget(t *testing.T) {
+	var out bytes.Buffer
+	r := newREPL(map[Symbol]Expr{}, &out, "")
+	r.budget = 1000
+	input := "ap ap ap s i i ap ap s i i\nap ap add 1 2\n"
+	assert.NoError(t, r.run(strings.NewReader(input), false))
+	assert.Equal(t, "error: reduction budget exhausted\n3\n", out.String())
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sender delivers data that galaxy asked to send and returns the aliens'
+// response.
+type Sender interface {
+	Send(ctx context.Context, data Expr) (Expr, error)
+}
+
+// SenderFunc adapts a function to a Sender.
+type SenderFunc func(ctx context.Context, data Expr) (Expr, error)
+
+func (f SenderFunc) Send(ctx context.Context, data Expr) (Expr, error) {
+	return f(ctx, data)
+}
+
+// sendToAliens is where interactions send data. When it is nil, an
+// interaction that wants to send stops and reports the data instead.
+var sendToAliens Sender
+
+// HTTPSender POSTs modulated data to URL and demodulates the response body.
+type HTTPSender struct {
+	URL string
+}
+
+func (s HTTPSender) Send(ctx context.Context, data Expr) (Expr, error) {
+	body, err := modulate(data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	byts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("send failed: %s: %s", resp.Status, bytes.TrimSpace(byts))
+	}
+	return demodulate(string(bytes.TrimSpace(byts)))
+}
+
+// sendRecord is one line of a send log: a modulated request and either the
+// modulated response or the error the send failed with.
+type sendRecord struct {
+	Request  string `json:"request"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Recorder passes sends on to Sender and appends each request and response
+// to a log, one JSON object per line, that a Replayer can serve.
+type Recorder struct {
+	Sender Sender
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// newRecorder records the sends of sender to the file at path, appending
+// to it if it exists.
+func newRecorder(sender Sender, path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{Sender: sender, out: f}, nil
+}
+
+func (r *Recorder) Send(ctx context.Context, data Expr) (Expr, error) {
+	request, err := modulate(data)
+	if err != nil {
+		return nil, err
+	}
+	response, sendErr := r.Sender.Send(ctx, data)
+	record := sendRecord{Request: request}
+	if sendErr != nil {
+		record.Error = sendErr.Error()
+	} else if record.Response, err = modulate(response); err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.out.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("recording send: %w", err)
+	}
+	return response, sendErr
+}
+
+// errNotRecorded is returned by a Replayer for a request its log doesn't
+// have an answer for.
+var errNotRecorded = errors.New("no recorded response for request")
+
+// Replayer answers sends from a log written by a Recorder. A request that
+// was recorded several times gets the recorded answers in order, and the
+// last one again once they run out.
+type Replayer struct {
+	mu      sync.Mutex
+	answers map[string][]sendRecord
+}
+
+// loadReplayer reads the send log at path.
+func loadReplayer(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readReplayer(f)
+}
+
+func readReplayer(r io.Reader) (*Replayer, error) {
+	rp := &Replayer{answers: map[string][]sendRecord{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record sendRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rp.answers[record.Request] = append(rp.answers[record.Request], record)
+	}
+	return rp, scanner.Err()
+}
+
+func (rp *Replayer) Send(ctx context.Context, data Expr) (Expr, error) {
+	request, err := modulate(data)
+	if err != nil {
+		return nil, err
+	}
+	rp.mu.Lock()
+	answers := rp.answers[request]
+	if len(answers) == 0 {
+		rp.mu.Unlock()
+		return nil, fmt.Errorf("%w %s", errNotRecorded, request)
+	}
+	record := answers[0]
+	if len(answers) > 1 {
+		rp.answers[request] = answers[1:]
+	}
+	rp.mu.Unlock()
+	if record.Error != "" {
+		return nil, errors.New(record.Error)
+	}
+	return demodulate(record.Response)
+}
+
+// errInjectedFault is the error a FaultInjector fails sends with.
+var errInjectedFault = errors.New("injected fault")
+
+// FaultInjector makes the link to the aliens flaky: every send waits
+// Latency, fails with probability ErrorRate, and has each bit of its
+// modulated response flipped with probability GarbleRate. Faults are drawn
+// from a seeded source, so a flaky session can be reproduced.
+type FaultInjector struct {
+	Sender     Sender
+	Latency    time.Duration
+	ErrorRate  float64
+	GarbleRate float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFaultInjector(sender Sender, seed uint64) *FaultInjector {
+	return &FaultInjector{Sender: sender, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (f *FaultInjector) Send(ctx context.Context, data Expr) (Expr, error) {
+	if f.Latency > 0 {
+		timer := time.NewTimer(f.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+	if f.chance(f.ErrorRate) {
+		return nil, errInjectedFault
+	}
+	response, err := f.Sender.Send(ctx, data)
+	if err != nil || f.GarbleRate <= 0 {
+		return response, err
+	}
+	signal, err := modulate(response)
+	if err != nil {
+		return nil, err
+	}
+	bits := []byte(signal)
+	for i, b := range bits {
+		if f.chance(f.GarbleRate) {
+			bits[i] = '0' + '1' - b
+		}
+	}
+	garbled, err := demodulate(string(bits))
+	if err != nil {
+		return nil, fmt.Errorf("garbled response: %w", err)
+	}
+	return garbled, nil
+}
+
+func (f *FaultInjector) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64() < p
+}
+
+// parseFaults configures f from a comma separated list of latency=DURATION,
+// errors=RATE, garble=RATE and seed=N settings.
+func (f *FaultInjector) parseFaults(spec string) error {
+	for _, setting := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return fmt.Errorf("invalid fault %q, want name=value", setting)
+		}
+		var err error
+		switch name {
+		case "latency":
+			f.Latency, err = time.ParseDuration(value)
+		case "errors":
+			f.ErrorRate, err = strconv.ParseFloat(value, 64)
+		case "garble":
+			f.GarbleRate, err = strconv.ParseFloat(value, 64)
+		case "seed":
+			var seed uint64
+			seed, err = strconv.ParseUint(value, 10, 64)
+			f.rng = rand.New(rand.NewPCG(seed, seed))
+		default:
+			return fmt.Errorf("unknown fault %q, want latency, errors, garble or seed", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid fault %q: %w", setting, err)
+		}
+	}
+	return nil
+}
+
+// newSender builds the sender the send flags describe: the stand-in when
+// url is "standin", an HTTPSender for any other url, or a Replayer of the
+// log at replay. faults, when set, make it flaky, and record logs its sends
+// as the interaction saw them. It returns nil when there is nothing to
+// send to.
+func newSender(url, replay, record, faults string) (Sender, error) {
+	var sender Sender
+	switch {
+	case replay != "":
+		rp, err := loadReplayer(replay)
+		if err != nil {
+			return nil, fmt.Errorf("loading send log: %w", err)
+		}
+		sender = rp
+	case url == "standin":
+		sender = aliens
+	case url != "":
+		sender = HTTPSender{URL: url}
+	default:
+		return nil, nil
+	}
+	if faults != "" {
+		f := newFaultInjector(sender, 1)
+		if err := f.parseFaults(faults); err != nil {
+			return nil, err
+		}
+		sender = f
+	}
+	if record != "" {
+		r, err := newRecorder(sender, record)
+		if err != nil {
+			return nil, fmt.Errorf("opening send log: %w", err)
+		}
+		sender = r
+	}
+	return sender, nil
+}
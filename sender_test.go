@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoSender answers every send with [data, n] where n counts the sends.
+func echoSender() Sender {
+	n := 0
+	return SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		n++
+		return List{toValue(data), Int(n)}.ToExpr(), nil
+	})
+}
+
+func TestHTTPSender(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "link down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("1101000\n"))
+	}))
+	defer srv.Close()
+
+	response, err := HTTPSender{URL: srv.URL}.Send(context.Background(), Number(1))
+	require.NoError(t, err)
+	assert.Equal(t, "ap ap cons 0 nil", printExpr(response))
+
+	_, err = HTTPSender{URL: srv.URL + "/down"}.Send(context.Background(), Number(1))
+	assert.EqualError(t, err, "send failed: 502 Bad Gateway: link down")
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sends.jsonl")
+	failing := SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		return nil, errors.New("link down")
+	})
+	echo := echoSender()
+	rec, err := newRecorder(SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		if data == Number(9) {
+			return failing(ctx, data)
+		}
+		return echo.Send(ctx, data)
+	}), path)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, data := range []Expr{Number(1), Number(2), Number(1), Number(9)} {
+		rec.Send(ctx, data)
+	}
+	log, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"request":"01100001","response":"1101100001110110000100"}
+{"request":"01100010","response":"1101100010110110001000"}
+{"request":"01100001","response":"1101100001110110001100"}
+{"request":"01101001","error":"link down"}
+`, string(log))
+
+	rp, err := loadReplayer(path)
+	require.NoError(t, err)
+	for _, want := range []string{"[1 1]", "[1 3]", "[1 3]"} {
+		response, err := rp.Send(ctx, Number(1))
+		require.NoError(t, err)
+		assert.Equal(t, want, toValue(response).String())
+	}
+	_, err = rp.Send(ctx, Number(9))
+	assert.EqualError(t, err, "link down")
+	_, err = rp.Send(ctx, Number(5))
+	assert.ErrorIs(t, err, errNotRecorded)
+
+	_, err = readReplayer(strings.NewReader("{\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestFaultInjector(t *testing.T) {
+	ctx := context.Background()
+	f := newFaultInjector(echoSender(), 1)
+	require.NoError(t, f.parseFaults("errors=1"))
+	_, err := f.Send(ctx, Number(1))
+	assert.ErrorIs(t, err, errInjectedFault)
+
+	// Flipping every bit turns the pair tag 11 into nil, 00, and leaves
+	// the rest over.
+	f = newFaultInjector(echoSender(), 1)
+	require.NoError(t, f.parseFaults("garble=1"))
+	_, err = f.Send(ctx, Number(1))
+	assert.EqualError(t, err, `garbled response: trailing bits after signal: "10011110001001111011"`)
+
+	f = newFaultInjector(echoSender(), 1)
+	require.NoError(t, f.parseFaults("latency=1h"))
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = f.Send(ctx, Number(1))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.ErrorContains(t, f.parseFaults("latency"), "want name=value")
+	assert.ErrorContains(t, f.parseFaults("errors=often"), `invalid fault "errors=often"`)
+	assert.ErrorContains(t, f.parseFaults("lag=1s"), `unknown fault "lag"`)
+}
+
+func TestFaultInjectorIsReproducible(t *testing.T) {
+	outcomes := func() string {
+		f := newFaultInjector(echoSender(), 1)
+		require.NoError(t, f.parseFaults("errors=0.5,seed=42"))
+		var sb strings.Builder
+		for i := 0; i < 20; i++ {
+			if _, err := f.Send(context.Background(), Number(1)); err != nil {
+				sb.WriteByte('x')
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		return sb.String()
+	}
+	first := outcomes()
+	assert.Equal(t, first, outcomes())
+	assert.Contains(t, first, "x")
+	assert.Contains(t, first, ".")
+}
+
+func TestNewSender(t *testing.T) {
+	sender, err := newSender("", "", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, sender)
+
+	sender, err = newSender("standin", "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, aliens, sender)
+
+	sender, err = newSender("http://localhost:1", "", filepath.Join(t.TempDir(), "log"), "errors=1")
+	require.NoError(t, err)
+	require.IsType(t, &Recorder{}, sender)
+	assert.IsType(t, &FaultInjector{}, sender.(*Recorder).Sender)
+	assert.Equal(t, HTTPSender{URL: "http://localhost:1"}, sender.(*Recorder).Sender.(*FaultInjector).Sender)
+
+	_, err = newSender("", filepath.Join(t.TempDir(), "missing"), "", "")
+	assert.ErrorContains(t, err, "loading send log")
+	_, err = newSender("standin", "", "", "lag=1s")
+	assert.Error(t, err)
+}
+
+func TestInteractOverFlakyLink(t *testing.T) {
+	symbols, err := parseProgramText(senderProgram)
+	require.NoError(t, err)
+	f := newFaultInjector(SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		return List{Int(7), Int(8)}.ToExpr(), nil
+	}), 1)
+	require.NoError(t, f.parseFaults("errors=1"))
+	withSender(t, f)
+	_, err = interact(context.Background(), symbols, Symbol("nil"), 0, 0)
+	assert.EqualError(t, err, "send failed: injected fault")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Default timeouts of the serve command.
+const (
+	defaultReadTimeout     = 30 * time.Second
+	defaultWriteTimeout    = 2 * time.Minute
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+// defaultEvalTimeout returns the evaluation timeout serve uses when none is
+// given: most of the write timeout, so that an evaluation that runs out of
+// time can still be answered with an error, or 0 when writes have no
+// timeout either.
+func (cfg serverConfig) defaultEvalTimeout() time.Duration {
+	return cfg.writeTimeout * 9 / 10
+}
+
+// errShuttingDown is the cause evaluations are cancelled with when the
+// server shuts down.
+var errShuttingDown = errors.New("server shutting down")
+
+// serverConfig holds the settings of the HTTP server.
+type serverConfig struct {
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	tlsCert         string
+	tlsKey          string
+	// stateFile is where the state store is loaded from on start and saved
+	// to on shutdown; empty to keep it in memory only.
+	stateFile string
+}
+
+// runServer serves handler on ln until ctx is done, and then shuts down:
+// it stops accepting connections, cancels the evaluations of requests in
+// flight, waits up to shutdownTimeout for their handlers to return and
+// saves the state store.
+func runServer(ctx context.Context, ln net.Listener, handler http.Handler, cfg serverConfig) error {
+	if cfg.stateFile != "" {
+		if err := states.load(cfg.stateFile); err != nil {
+			return fmt.Errorf("loading states: %w", err)
+		}
+	}
+
+	base, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.readTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if cfg.tlsCert != "" || cfg.tlsKey != "" {
+			served <- srv.ServeTLS(ln, cfg.tlsCert, cfg.tlsKey)
+		} else {
+			served <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+	cancel(errShuttingDown)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancelShutdown()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		err = fmt.Errorf("shutting down: %w", err)
+		srv.Close()
+	}
+	if cfg.stateFile != "" {
+		if saveErr := states.save(cfg.stateFile); saveErr != nil {
+			err = errors.Join(err, fmt.Errorf("saving states: %w", saveErr))
+		}
+	}
+	if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+		err = errors.Join(err, serveErr)
+	}
+	return err
+}
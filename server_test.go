@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, cfg serverConfig) (url string, stop func() error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runServer(ctx, ln, newMux(), cfg) }()
+	return "http://" + ln.Addr().String(), func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestShutdownCancelsEvaluations(t *testing.T) {
+	previous := evalTimeout
+	evalTimeout = 0
+	t.Cleanup(func() { evalTimeout = previous })
+	url, stop := startServer(t, serverConfig{shutdownTimeout: 5 * time.Second})
+
+	type result struct {
+		status int
+		body   EvalResponse
+	}
+	results := make(chan result)
+	go func() {
+		// This never terminates on its own.
+		resp, err := http.Post(url+"/eval", "application/json", strings.NewReader(`{"expression": "ap ap ap s i i ap ap s i i"}`))
+		if !assert.NoError(t, err) {
+			results <- result{}
+			return
+		}
+		defer resp.Body.Close()
+		var body EvalResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		results <- result{resp.StatusCode, body}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, stop())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	r := <-results
+	assert.Equal(t, http.StatusServiceUnavailable, r.status)
+	assert.Equal(t, "server shutting down", r.body.Error)
+
+	_, err := http.Get(url + "/")
+	assert.Error(t, err)
+}
+
+func TestShutdownSavesStates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "states.json")
+	url, stop := startServer(t, serverConfig{shutdownTimeout: time.Second, stateFile: path})
+	resp, err := http.Post(url+"/interact", "application/json", strings.NewReader(`{"state": "nil"}`))
+	require.NoError(t, err)
+	var interacted InteractResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&interacted))
+	resp.Body.Close()
+	require.NoError(t, stop())
+
+	restored := newStateStore()
+	require.NoError(t, restored.load(path))
+	stored, ok := restored.get(interacted.Hash)
+	require.True(t, ok)
+	assert.Equal(t, interacted.NewState, stored.State)
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile)
+	url, stop := startServer(t, serverConfig{shutdownTimeout: time.Second, tlsCert: certFile, tlsKey: keyFile})
+	defer stop()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https" + strings.TrimPrefix(url, "http") + "/programs")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotNil(t, resp.TLS)
+}
+
+func writeSelfSignedCert(t *testing.T, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func TestStreamDeadlineFollowsEvalTimeout(t *testing.T) {
+	assert.Equal(t, 108*time.Second, serverConfig{writeTimeout: 2 * time.Minute}.defaultEvalTimeout())
+	assert.Zero(t, serverConfig{}.defaultEvalTimeout())
+
+	symbols, err := parseProgramText(senderProgram)
+	require.NoError(t, err)
+	programs.add("sender", symbols)
+	t.Cleanup(func() { programs.remove("sender") })
+	// The aliens never answer, so only the evaluation timeout ends the
+	// interaction, after the write timeout.
+	withSender(t, SenderFunc(func(ctx context.Context, data Expr) (Expr, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	previous := evalTimeout
+	evalTimeout = 300 * time.Millisecond
+	t.Cleanup(func() { evalTimeout = previous })
+	url, stop := startServer(t, serverConfig{writeTimeout: 100 * time.Millisecond, shutdownTimeout: time.Second})
+	defer stop()
+
+	resp, err := http.Get(url + "/interact/stream?program=sender&state=nil&x=0&y=0")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "event: send\n")
+	assert.Contains(t, string(body), "event: failed\ndata: {\"flag\":0,\"newstate\":\"\",\"images\":null,\"error\":\"send failed: evaluation timed out\"}")
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lukehoban/icfp2020/physics"
+)
+
+// Rules of games on the stand-in server.
+const (
+	standInMaxTicks    = 256
+	standInTickTimeout = time.Second
+	standInMaxHeat     = 64
+	standInMaxBoost    = 2
+	// standInMaxGames bounds the games in play; creating more fails.
+	standInMaxGames = 1024
+	// A game is dropped once nobody sent a request for it for
+	// standInGameTTL, or standInFinishedTTL after it finished, which
+	// leaves the players time to see the result.
+	standInGameTTL     = 10 * time.Minute
+	standInFinishedTTL = time.Minute
+)
+
+var (
+	standInPlanet = PlanetInfo{Radius: 16, SafeRadius: 128}
+	// standInLimits are the maximum parameter cost, laser power and
+	// cooling, by role.
+	standInLimits = [2][]int64{{512, 64, 128}, {448, 64, 128}}
+	// standInStarts are where the first ship of each role starts.
+	standInStarts = [2]Vec{{X: -48, Y: -48}, {X: 48, Y: 48}}
+)
+
+// paramsCost is what ship parameters cost against the first limit.
+func paramsCost(p ShipParams) int64 {
+	return p.Fuel + 4*p.Laser + 12*p.Cooling + 2*p.Copies
+}
+
+// standIn is an in-process stand-in for the alien game server, so that the
+// game client and bots can be run and tested offline. Ticks advance once
+// both players sent their commands, or after tickTimeout. Games are keyed
+// by both of their player keys.
+type standIn struct {
+	mu          sync.Mutex
+	rng         *rand.Rand
+	games       map[int64]*standInGame
+	tickTimeout time.Duration
+	now         func() time.Time
+}
+
+type standInPlayer struct {
+	key     int64
+	role    int64
+	joined  bool
+	started bool
+	params  ShipParams
+	// commands are the commands sent for the current tick, nil until the
+	// player sent them.
+	commands []Value
+}
+
+type standInGame struct {
+	players [2]*standInPlayer
+	stage   int64
+	state   physics.State
+	// events are the commands carried out in the last tick.
+	events []physics.Event
+	// advanced is closed and replaced whenever the game starts or a tick
+	// passes.
+	advanced chan struct{}
+	// expires is when the game is dropped.
+	expires time.Time
+}
+
+// aliens is the stand-in served at /aliens/send.
+var aliens = newStandIn(1)
+
+func newStandIn(seed uint64) *standIn {
+	return &standIn{
+		rng:         rand.New(rand.NewPCG(seed, seed)),
+		games:       map[int64]*standInGame{},
+		tickTimeout: standInTickTimeout,
+		now:         time.Now,
+	}
+}
+
+// Send handles one request like the alien server.
+func (s *standIn) Send(ctx context.Context, data Expr) (Expr, error) {
+	response, err := s.handle(ctx, toValue(data))
+	if err != nil {
+		return nil, err
+	}
+	return response.ToExpr(), nil
+}
+
+var standInError = List{Int(0)}
+
+// handle answers a request. Requests the alien server would refuse get
+// standInError; an error means the response couldn't be built.
+func (s *standIn) handle(ctx context.Context, request Value) (Value, error) {
+	var req struct {
+		Type int64 `galaxy:"0"`
+	}
+	if err := decodeValue(request, &req); err != nil {
+		return standInError, nil
+	}
+	if req.Type == requestCreate {
+		return s.create(), nil
+	}
+
+	var keyed struct {
+		Key  int64 `galaxy:"1"`
+		Data Value `galaxy:"2"`
+	}
+	if err := decodeValue(request, &keyed); err != nil {
+		return standInError, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.games[keyed.Key]
+	if !ok || s.expired(g) {
+		return standInError, nil
+	}
+	g.touch(s.now())
+	p := g.player(keyed.Key)
+	switch req.Type {
+	case requestJoin:
+		p.joined = true
+		return g.response(p)
+	case requestStart:
+		return s.start(ctx, g, p, keyed.Data)
+	case requestCommands:
+		return s.commands(ctx, g, p, keyed.Data)
+	}
+	return standInError, nil
+}
+
+// create starts a game, after dropping the expired ones, unless
+// standInMaxGames are in play.
+func (s *standIn) create() Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, g := range s.games {
+		if s.expired(g) {
+			delete(s.games, key)
+		}
+	}
+	if len(s.games)/2 >= standInMaxGames {
+		return standInError
+	}
+	g := &standInGame{advanced: make(chan struct{})}
+	g.touch(s.now())
+	for role := range g.players {
+		key := s.rng.Int64N(1<<40) + 1
+		for s.games[key] != nil {
+			key = s.rng.Int64N(1<<40) + 1
+		}
+		g.players[role] = &standInPlayer{key: key, role: int64(role)}
+		s.games[key] = g
+	}
+	return List{Int(1), List{
+		List{Int(RoleAttacker), Int(g.players[RoleAttacker].key)},
+		List{Int(RoleDefender), Int(g.players[RoleDefender].key)},
+	}}
+}
+
+// expired reports whether g is to be dropped.
+func (s *standIn) expired(g *standInGame) bool {
+	return !s.now().Before(g.expires)
+}
+
+func (s *standIn) start(ctx context.Context, g *standInGame, p *standInPlayer, data Value) (Value, error) {
+	var params ShipParams
+	if err := decodeValue(data, &params); err != nil || !p.joined || p.started || g.stage != StageNotStarted {
+		return standInError, nil
+	}
+	limits := standInLimits[p.role]
+	if params.Fuel < 0 || params.Laser < 0 || params.Cooling < 0 || params.Copies < 1 ||
+		paramsCost(params) > limits[0] || params.Laser > limits[1] || params.Cooling > limits[2] {
+		return standInError, nil
+	}
+	p.started, p.params = true, params
+	if g.players[0].started && g.players[1].started {
+		g.begin()
+	} else {
+		s.wait(ctx, g)
+	}
+	return g.response(p)
+}
+
+func (s *standIn) commands(ctx context.Context, g *standInGame, p *standInPlayer, data Value) (Value, error) {
+	if g.stage != StageStarted {
+		return g.response(p)
+	}
+	commands, err := data.AsList()
+	if err != nil {
+		return standInError, nil
+	}
+	p.commands = commands
+	opponent := g.players[1-p.role]
+	if opponent.commands == nil && len(g.shipsOf(opponent.role)) > 0 {
+		tick := g.state.Tick
+		s.wait(ctx, g)
+		if g.state.Tick != tick {
+			return g.response(p)
+		}
+	}
+	g.step()
+	if g.stage == StageFinished {
+		g.expires = s.now().Add(standInFinishedTTL)
+	}
+	return g.response(p)
+}
+
+// wait releases the lock until the game advances, tickTimeout passes or
+// ctx is done.
+func (s *standIn) wait(ctx context.Context, g *standInGame) {
+	advanced := g.advanced
+	s.mu.Unlock()
+	timer := time.NewTimer(s.tickTimeout)
+	select {
+	case <-advanced:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	timer.Stop()
+	s.mu.Lock()
+}
+
+func (g *standInGame) player(key int64) *standInPlayer {
+	if g.players[0].key == key {
+		return g.players[0]
+	}
+	return g.players[1]
+}
+
+// touch keeps a game that hasn't finished for another standInGameTTL.
+func (g *standInGame) touch(now time.Time) {
+	if g.stage != StageFinished {
+		g.expires = now.Add(standInGameTTL)
+	}
+}
+
+func (g *standInGame) advance() {
+	close(g.advanced)
+	g.advanced = make(chan struct{})
+}
+
+func (g *standInGame) begin() {
+	g.stage = StageStarted
+	for _, p := range g.players {
+		g.state.Ships = append(g.state.Ships, physics.Ship{
+			Role:     p.role,
+			ID:       g.state.NextID,
+			Position: physics.Vec(standInStarts[p.role]),
+			Params:   physics.Params(p.params),
+			MaxHeat:  standInMaxHeat,
+			MaxBoost: standInMaxBoost,
+		})
+		g.state.NextID++
+	}
+	g.advance()
+}
+
+func (g *standInGame) shipsOf(role int64) []physics.Ship {
+	var ships []physics.Ship
+	for _, ship := range g.state.Ships {
+		if ship.Role == role {
+			ships = append(ships, ship)
+		}
+	}
+	return ships
+}
+
+// step plays a tick with the commands of both players.
+func (g *standInGame) step() {
+	var commands []physics.Command
+	for _, p := range g.players {
+		for _, cmd := range p.commands {
+			if c, ok := g.command(p.role, cmd); ok {
+				commands = append(commands, c)
+			}
+		}
+		p.commands = nil
+	}
+	g.state, g.events = physics.Step(standInPlanet.rules(), g.state, commands)
+	if g.state.Tick >= standInMaxTicks || len(g.shipsOf(RoleAttacker)) == 0 || len(g.shipsOf(RoleDefender)) == 0 {
+		g.stage = StageFinished
+	}
+	g.advance()
+}
+
+// command decodes a command a player sent, which must be for one of their
+// own ships.
+func (g *standInGame) command(role int64, v Value) (physics.Command, bool) {
+	var cmd struct {
+		Type   int64 `galaxy:"0"`
+		ShipID int64 `galaxy:"1"`
+	}
+	if decodeValue(v, &cmd) != nil {
+		return physics.Command{}, false
+	}
+	owned := false
+	for _, ship := range g.shipsOf(role) {
+		owned = owned || ship.ID == cmd.ShipID
+	}
+	if !owned {
+		return physics.Command{}, false
+	}
+	c := physics.Command{ShipID: cmd.ShipID}
+	var err error
+	switch cmd.Type {
+	case commandAccelerate:
+		var accel struct {
+			Vector Vec `galaxy:"2"`
+		}
+		err = decodeValue(v, &accel)
+		c.Kind, c.Vector = physics.Accelerate, physics.Vec(accel.Vector)
+	case commandDetonate:
+		c.Kind = physics.Detonate
+	case commandShoot:
+		var shoot struct {
+			Target Vec   `galaxy:"2"`
+			Power  int64 `galaxy:"3"`
+		}
+		err = decodeValue(v, &shoot)
+		c.Kind, c.Vector, c.Power = physics.Shoot, physics.Vec(shoot.Target), shoot.Power
+	case commandFork:
+		var fork struct {
+			Params ShipParams `galaxy:"2"`
+		}
+		err = decodeValue(v, &fork)
+		c.Kind, c.Params = physics.Fork, physics.Params(fork.Params)
+	default:
+		return physics.Command{}, false
+	}
+	return c, err == nil
+}
+
+// eventValue encodes a carried out command the way the game state reports
+// it: accelerations with their vector, detonations with their blast, shots
+// with their target, power and damage, and forks with the new ship's
+// parameters.
+func eventValue(ev physics.Event) Value {
+	switch ev.Kind {
+	case physics.Accelerate:
+		return List{Int(commandAccelerate), Vec(ev.Vector).galaxyValue()}
+	case physics.Detonate:
+		return List{Int(commandDetonate), Int(ev.Power)}
+	case physics.Shoot:
+		return List{Int(commandShoot), Vec(ev.Vector).galaxyValue(), Int(ev.Power), Int(ev.Damage)}
+	default:
+		return List{Int(commandFork), paramsValue(ShipParams(ev.Params))}
+	}
+}
+
+// response builds the game response a player sees.
+func (g *standInGame) response(p *standInPlayer) (Value, error) {
+	planet := standInPlanet
+	info := GameInfo{
+		MaxTicks: standInMaxTicks,
+		Role:     p.role,
+		Limits:   standInLimits[p.role],
+		Planet:   &planet,
+	}
+	if p.role == RoleAttacker && g.players[RoleDefender].started {
+		opponent := g.players[RoleDefender].params
+		info.Opponent = &opponent
+	}
+	var state *GameState
+	if g.stage != StageNotStarted {
+		state = &GameState{Tick: g.state.Tick, Bound: &planet}
+		for _, ship := range g.state.Ships {
+			ss := ShipState{Ship: Ship{
+				Role:     ship.Role,
+				ID:       ship.ID,
+				Position: Vec(ship.Position),
+				Velocity: Vec(ship.Velocity),
+				Params:   ShipParams(ship.Params),
+				Heat:     ship.Heat,
+				MaxHeat:  ship.MaxHeat,
+				MaxBoost: ship.MaxBoost,
+			}}
+			for _, ev := range g.events {
+				if ev.ShipID == ship.ID {
+					ss.Commands = append(ss.Commands, eventValue(ev))
+				}
+			}
+			state.Ships = append(state.Ships, ss)
+		}
+	}
+	infoValue, err := encodeValue(info)
+	if err != nil {
+		return nil, err
+	}
+	stateValue, err := encodeValue(state)
+	if err != nil {
+		return nil, err
+	}
+	return List{Int(1), Int(g.stage), infoValue, stateValue}, nil
+}
+
+// aliensSendHandler serves the stand-in like the alien server's send
+// endpoint: the request and response bodies are modulated.
+func aliensSendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, err := demodulate(string(bytes.TrimSpace(body)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid signal: %v", err), http.StatusBadRequest)
+		return
+	}
+	response, err := aliens.Send(r.Context(), request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signal, err := modulate(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, signal)
+}
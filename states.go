@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Bounds of the state store: the number of states and their total size,
+// as JSON, beyond which the oldest states are dropped, and the size of the
+// largest state that is stored at all.
+const (
+	maxStoredStates     = 10000
+	maxStoredStateBytes = 64 << 20
+	maxStateBytes       = 1 << 20
+)
+
+// StoredState is an interaction state together with the images it was
+// produced with, addressed by the hash of its content.
+type StoredState struct {
+	Hash      string        `json:"hash"`
+	Program   string        `json:"program"`
+	State     string        `json:"state"`
+	Images    [][]PointPair `json:"images"`
+	CreatedAt time.Time     `json:"createdAt"`
+
+	// size is the length of the state's JSON encoding.
+	size int
+}
+
+type StateResponse struct {
+	*StoredState
+	Error string `json:"error,omitempty"`
+}
+
+// stateStore is a content-addressed store of interaction states, so that a
+// screen can be shared as a short link.
+type stateStore struct {
+	mu     sync.RWMutex
+	states map[string]StoredState
+	order  []string
+	// bytes is the total size of the states.
+	bytes int
+}
+
+var states = newStateStore()
+
+func newStateStore() *stateStore {
+	return &stateStore{states: map[string]StoredState{}}
+}
+
+// stateHash returns the content hash of a stored state: the program it
+// belongs to, by name and source hash, the printed form of the state's
+// expression and its images. The same state of different programs, or of
+// different versions of one, gets different hashes.
+func stateHash(program, programHash, state string, images [][]PointPair) string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode([]any{program, programHash, state, images})
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// put stores the new state and images of an interaction response with prog.
+// A state larger than maxStateBytes is not stored and has no hash.
+func (s *stateStore) put(prog *program, resp InteractResponse) StoredState {
+	stored := StoredState{
+		Hash:      stateHash(prog.name, prog.hash, resp.NewState, resp.Images),
+		Program:   prog.name,
+		State:     resp.NewState,
+		Images:    resp.Images,
+		CreatedAt: time.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.states[stored.Hash]; ok {
+		return existing
+	}
+	if !stored.measure() {
+		stored.Hash = ""
+		return stored
+	}
+	s.add(stored)
+	s.evict()
+	return stored
+}
+
+// measure sets the size of a state, reporting whether it is small enough
+// to be stored.
+func (stored *StoredState) measure() bool {
+	byts, err := json.Marshal(stored)
+	stored.size = len(byts)
+	return err == nil && stored.size <= maxStateBytes
+}
+
+func (s *stateStore) add(stored StoredState) {
+	s.states[stored.Hash] = stored
+	s.order = append(s.order, stored.Hash)
+	s.bytes += stored.size
+}
+
+// evict drops the oldest states until the store is within its bounds.
+func (s *stateStore) evict() {
+	for len(s.order) > maxStoredStates || s.bytes > maxStoredStateBytes {
+		s.bytes -= s.states[s.order[0]].size
+		delete(s.states, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *stateStore) get(hash string) (StoredState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.states[hash]
+	return stored, ok
+}
+
+// len returns how many states are stored.
+func (s *stateStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.order)
+}
+
+// save writes the stored states to path, oldest first. The file is
+// replaced at once, so a crash while saving leaves the previous one.
+func (s *stateStore) save(path string) error {
+	s.mu.RLock()
+	stored := make([]StoredState, len(s.order))
+	for i, hash := range s.order {
+		stored[i] = s.states[hash]
+	}
+	s.mu.RUnlock()
+	byts, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, byts, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load adds the states saved at path, if it exists, to the store.
+func (s *stateStore) load(path string) error {
+	byts, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored []StoredState
+	if err := json.Unmarshal(byts, &stored); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, state := range stored {
+		if _, ok := s.states[state.Hash]; !ok && state.measure() {
+			s.add(state)
+		}
+	}
+	s.evict()
+	return nil
+}
+
+// stateHandler returns a stored state by hash.
+func stateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(StateResponse{Error: "Method not allowed"})
+		return
+	}
+
+	stored, ok := states.get(r.PathValue("hash"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(StateResponse{Error: "Unknown state"})
+		return
+	}
+	json.NewEncoder(w).Encode(StateResponse{StoredState: &stored})
+}
+
+// permalinkHandler opens the web UI at a stored state.
+func permalinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	stored, ok := states.get(r.PathValue("hash"))
+	if !ok {
+		http.Error(w, "Unknown state", http.StatusNotFound)
+		return
+	}
+	writePage(w, &stored)
+}
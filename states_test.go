@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatePermalinks(t *testing.T) {
+	rr := serve(http.MethodPost, "/interact", `{"state": "nil", "point": {"x": 0, "y": 0}}`)
+	var resp InteractResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	prog, ok := programs.get(defaultProgram)
+	require.True(t, ok)
+	assert.Equal(t, stateHash(defaultProgram, prog.hash, resp.NewState, resp.Images), resp.Hash)
+	assert.Len(t, resp.Hash, 32)
+
+	rr = serve(http.MethodGet, "/states/"+resp.Hash, "")
+	var stored StateResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stored))
+	assert.Equal(t, "galaxy", stored.Program)
+	assert.Equal(t, resp.NewState, stored.State)
+	assert.Equal(t, resp.Images, stored.Images)
+
+	rr = serve(http.MethodGet, "/s/"+resp.Hash, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `const initialState = {"hash":"`+resp.Hash+`"`)
+
+	// The plain page has no initial state.
+	rr = serve(http.MethodGet, "/", "")
+	assert.Contains(t, rr.Body.String(), "const initialState = null;")
+
+	rr = serve(http.MethodGet, "/states/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serve(http.MethodGet, "/s/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestStateStoreHashesContent(t *testing.T) {
+	s := newStateStore()
+	galaxy := &program{name: "galaxy", hash: "1111"}
+	resp := InteractResponse{NewState: "nil", Images: [][]PointPair{{{X: 1, Y: 2}}}}
+	first := s.put(galaxy, resp)
+	assert.Equal(t, first, s.put(galaxy, resp))
+
+	hashes := map[string]string{first.Hash: "galaxy"}
+	for name, stored := range map[string]StoredState{
+		"other program": s.put(&program{name: "other", hash: "1111"}, resp),
+		"new version":   s.put(&program{name: "galaxy", hash: "2222"}, resp),
+		"other images":  s.put(galaxy, InteractResponse{NewState: "nil", Images: [][]PointPair{}}),
+	} {
+		assert.NotContains(t, hashes, stored.Hash, name)
+		hashes[stored.Hash] = name
+	}
+	other, ok := s.get(first.Hash)
+	require.True(t, ok)
+	assert.Equal(t, "galaxy", other.Program)
+	assert.Equal(t, resp.Images, other.Images)
+}
+
+func TestStateStoreBound(t *testing.T) {
+	s := newStateStore()
+	galaxy := &program{name: "galaxy"}
+	first := s.put(galaxy, InteractResponse{NewState: "0"})
+	assert.Equal(t, first, s.put(galaxy, InteractResponse{NewState: "0"}))
+	for i := 1; i <= maxStoredStates; i++ {
+		s.put(galaxy, InteractResponse{NewState: strings.Repeat("1", i)})
+	}
+	_, ok := s.get(first.Hash)
+	assert.False(t, ok)
+	assert.Len(t, s.states, maxStoredStates)
+}
+
+func TestStateStoreByteBound(t *testing.T) {
+	s := newStateStore()
+	galaxy := &program{name: "galaxy"}
+	tooLarge := s.put(galaxy, InteractResponse{NewState: strings.Repeat("1", maxStateBytes)})
+	assert.Empty(t, tooLarge.Hash)
+	assert.Zero(t, s.len())
+
+	// States just under the size limit fill the store's bytes long before
+	// its count.
+	large := strings.Repeat("1", maxStateBytes-1000)
+	first := s.put(galaxy, InteractResponse{NewState: "0" + large})
+	for i := 1; i <= maxStoredStateBytes/maxStateBytes; i++ {
+		s.put(galaxy, InteractResponse{NewState: strconv.Itoa(i) + large})
+	}
+	_, ok := s.get(first.Hash)
+	assert.False(t, ok)
+	assert.LessOrEqual(t, s.bytes, maxStoredStateBytes)
+	assert.Less(t, s.len(), maxStoredStates)
+}
+
+func TestStateStoreSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "states.json")
+	store := newStateStore()
+	first := store.put(&program{name: "galaxy"}, InteractResponse{NewState: "nil"})
+	second := store.put(&program{name: "other"}, InteractResponse{NewState: "ap ap cons 1 nil", Images: [][]PointPair{{{X: 1, Y: 2}}}})
+	require.NoError(t, store.save(path))
+
+	loaded := newStateStore()
+	require.NoError(t, loaded.load(filepath.Join(t.TempDir(), "missing.json")))
+	require.NoError(t, loaded.load(path))
+	assert.Equal(t, []string{first.Hash, second.Hash}, loaded.order)
+	got, ok := loaded.get(second.Hash)
+	require.True(t, ok)
+	assert.Equal(t, second.Images, got.Images)
+	assert.True(t, second.CreatedAt.Equal(got.CreatedAt))
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	assert.Error(t, loaded.load(path))
+}
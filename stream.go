@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// streamWriteMargin is how long after its evaluation timeout a stream may
+// still be written to.
+const streamWriteMargin = 10 * time.Second
+
+// interactStreamHandler runs an interaction like interactHandler but streams
+// its progress as Server-Sent Events, one event per InteractEvent, named by
+// its type. Failures after the stream has started are sent as a "failed"
+// event carrying an InteractResponse with the error. It always runs the
+// full interaction protocol, whatever Send says. GET requests take the
+// state, x, y and program as query parameters so that pages can use
+// EventSource; POST requests take an InteractRequest body.
+func interactStreamHandler(w http.ResponseWriter, r *http.Request) {
+	var req InteractRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.State = query.Get("state")
+		req.Program = query.Get("program")
+		var errX, errY error
+		req.Point.X, errX = strconv.Atoi(query.Get("x"))
+		req.Point.Y, errY = strconv.Atoi(query.Get("y"))
+		if errX != nil || errY != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(InteractResponse{Error: "Invalid point"})
+			return
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			status, msg := bodyError(err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(InteractResponse{Error: msg})
+			return
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(InteractResponse{Error: "Method not allowed"})
+		return
+	}
+
+	stateExpr, errMsg := req.stateExpr()
+	if stateExpr == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(InteractResponse{Error: errMsg})
+		return
+	}
+
+	prog, ok := programs.get(req.Program)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(InteractResponse{Error: "Unknown program"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(InteractResponse{Error: "Streaming unsupported"})
+		return
+	}
+
+	// A stream lasts as long as the interaction, which may be longer than
+	// the server's write timeout allows: the deadline is moved to when the
+	// interaction times out, leaving streamWriteMargin to report it. Only
+	// when neither is limited is the stream unbounded.
+	deadline := time.Time{}
+	if evalTimeout > 0 {
+		deadline = time.Now().Add(evalTimeout + streamWriteMargin)
+	}
+	http.NewResponseController(w).SetWriteDeadline(deadline)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	serverMetrics.sessions.Add(1)
+	defer serverMetrics.sessions.Add(-1)
+
+	ctx, cancel := withEvalTimeout(prog.context(r.Context()))
+	defer cancel()
+	_, err := cachedInteract(ctx, prog, stateExpr, int64(req.Point.X), int64(req.Point.Y), true, func(ev InteractEvent) {
+		if ev.Result != nil {
+			ev.Result.Hash = states.put(prog, *ev.Result).Hash
+		}
+		writeEvent(w, ev.Type, ev)
+		flusher.Flush()
+	})
+	if err != nil {
+		writeEvent(w, "failed", InteractResponse{Error: err.Error()})
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one Server-Sent Event with a JSON payload.
+func writeEvent(w http.ResponseWriter, name string, payload interface{}) {
+	data, _ := json.Marshal(payload)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+}
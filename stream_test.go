@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInteractStream(t *testing.T) {
+	interactResults.flush()
+	rr := serve(http.MethodGet, "/interact/stream?state=nil&x=0&y=0", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+
+	var names []string
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+		}
+	}
+	assert.Equal(t, []string{"started", "flag", "image", "image", "image", "done"}, names)
+	assert.Contains(t, rr.Body.String(), "event: flag\ndata: {\"type\":\"flag\",\"round\":0,\"flag\":0}\n\n")
+	assert.Contains(t, rr.Body.String(), "event: image\ndata: {\"type\":\"image\",\"round\":0,\"layer\":0,")
+	assert.Contains(t, rr.Body.String(), "event: image\ndata: {\"type\":\"image\",\"round\":0,\"layer\":1,\"image\":[{\"x\":-7,\"y\":-3},{\"x\":-8,\"y\":-2}]}\n\n")
+	assert.Contains(t, rr.Body.String(), `"newstate":"ap ap cons 0 ap ap cons ap ap cons 0 nil ap ap cons 0 ap ap cons nil nil"`)
+
+	rr = serve(http.MethodPost, "/interact/stream", `{"state": "nil", "point": {"x": 0, "y": 0}}`)
+	assert.Contains(t, rr.Body.String(), "event: done\n")
+}
+
+func TestInteractStreamErrors(t *testing.T) {
+	for _, tt := range []struct {
+		method, target, body string
+		expectedStatus       int
+		expectedBody         string
+	}{
+		{http.MethodGet, "/interact/stream?state=nil&x=a&y=0", "", 400, `{"error": "Invalid point", "flag": 0, "newstate": "", "images": null}`},
+		{http.MethodGet, "/interact/stream?state=ap&x=0&y=0", "", 400, `{"error": "Invalid state expression", "flag": 0, "newstate": "", "images": null}`},
+		{http.MethodPost, "/interact/stream", "{", 400, `{"error": "Invalid JSON", "flag": 0, "newstate": "", "images": null}`},
+		{http.MethodPut, "/interact/stream", "", 405, `{"error": "Method not allowed", "flag": 0, "newstate": "", "images": null}`},
+	} {
+		rr := serve(tt.method, tt.target, tt.body)
+		assert.Equal(t, tt.expectedStatus, rr.Code, tt.target)
+		assert.JSONEq(t, tt.expectedBody, rr.Body.String(), tt.target)
+	}
+
+	rr := serve(http.MethodGet, "/interact/stream?state=ap+ap+cons+1+nil&x=0&y=0", "")
+	assert.Contains(t, rr.Body.String(), "event: failed\ndata: {\"flag\":0,\"newstate\":\"\",\"images\":null,\"error\":\"")
+}
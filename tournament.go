@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Match outcomes, by the role that won.
+const (
+	WinnerNone     = -1
+	WinnerAttacker = RoleAttacker
+	WinnerDefender = RoleDefender
+)
+
+// MatchResult reports the outcome of one match. Winner is a role, or
+// WinnerNone when both sides were destroyed.
+type MatchResult struct {
+	Attacker string `json:"attacker"`
+	Defender string `json:"defender"`
+	Seed     uint64 `json:"seed"`
+	Winner   int64  `json:"winner"`
+	Ticks    int64  `json:"ticks"`
+}
+
+// WinnerName returns the name of the winning bot, or "" for a draw.
+func (r MatchResult) WinnerName() string {
+	switch r.Winner {
+	case WinnerAttacker:
+		return r.Attacker
+	case WinnerDefender:
+		return r.Defender
+	}
+	return ""
+}
+
+// winner decides a finished game: a side without ships loses, and the
+// defender wins when both survive to the end.
+func winner(state *GameState) int64 {
+	attackers, defenders := len(state.ShipsOf(RoleAttacker)), len(state.ShipsOf(RoleDefender))
+	switch {
+	case attackers == 0 && defenders == 0:
+		return WinnerNone
+	case defenders == 0:
+		return WinnerAttacker
+	}
+	return WinnerDefender
+}
+
+// playMatch plays attacker against defender through client, sending the
+// requests of both players concurrently as the server expects.
+func playMatch(ctx context.Context, client *GameClient, attacker, defender Bot) (int64, *GameState, error) {
+	players := [2]Bot{attacker, defender}
+	var keys [2]int64
+	var err error
+	keys[RoleAttacker], keys[RoleDefender], err = client.Create(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("create: %w", err)
+	}
+	var responses [2]GameResponse
+	for role, key := range keys {
+		if responses[role], err = client.Join(ctx, key); err != nil {
+			return 0, nil, fmt.Errorf("join: %w", err)
+		}
+	}
+	if responses, err = both(func(role int) (GameResponse, error) {
+		return client.Start(ctx, keys[role], players[role].Start(responses[role].Info))
+	}); err != nil {
+		return 0, nil, fmt.Errorf("start: %w", err)
+	}
+	for responses[RoleAttacker].Stage == StageStarted {
+		if responses, err = both(func(role int) (GameResponse, error) {
+			resp := responses[role]
+			return client.Commands(ctx, keys[role], players[role].Commands(resp.Info, resp.State)...)
+		}); err != nil {
+			return 0, nil, fmt.Errorf("commands: %w", err)
+		}
+	}
+	state := responses[RoleAttacker].State
+	if responses[RoleAttacker].Stage != StageFinished || state == nil {
+		return 0, nil, fmt.Errorf("game ended in stage %d", responses[RoleAttacker].Stage)
+	}
+	return winner(state), state, nil
+}
+
+// both runs f for the two roles at once.
+func both(f func(role int) (GameResponse, error)) ([2]GameResponse, error) {
+	var responses [2]GameResponse
+	var errs [2]error
+	var wg sync.WaitGroup
+	for role := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[role], errs[role] = f(role)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return responses, err
+		}
+	}
+	return responses, nil
+}
+
+// runMatch plays a match between two named bots on a fresh stand-in seeded
+// with seed, which also seeds the bots.
+func runMatch(ctx context.Context, attacker, defender string, seed uint64) (MatchResult, error) {
+	result := MatchResult{Attacker: attacker, Defender: defender, Seed: seed}
+	a, err := newBot(attacker, rand.New(rand.NewPCG(seed, 1)))
+	if err != nil {
+		return result, err
+	}
+	d, err := newBot(defender, rand.New(rand.NewPCG(seed, 2)))
+	if err != nil {
+		return result, err
+	}
+	server := newStandIn(seed)
+	// Both players are in this process and answer at once; a long timeout
+	// keeps a slow machine from skipping a tick and changing the outcome.
+	server.tickTimeout = time.Minute
+	winner, state, err := playMatch(ctx, newGameClient(server), a, d)
+	if err != nil {
+		return result, err
+	}
+	result.Winner, result.Ticks = winner, state.Tick
+	return result, nil
+}
+
+// BotRecord is the tally of one bot in a tournament.
+type BotRecord struct {
+	Name    string  `json:"name"`
+	Wins    int     `json:"wins"`
+	Losses  int     `json:"losses"`
+	Draws   int     `json:"draws"`
+	WinRate float64 `json:"winRate"`
+}
+
+// TournamentResult reports every match of a tournament and the records of
+// the two bots.
+type TournamentResult struct {
+	Seed    uint64        `json:"seed"`
+	Matches []MatchResult `json:"matches"`
+	Bots    [2]BotRecord  `json:"bots"`
+}
+
+// playTournament plays n matches between bots a and b, swapping roles every
+// match. Match i is seeded with seed+i, so a tournament is reproducible
+// from its seed.
+func playTournament(ctx context.Context, a, b string, n int, seed uint64) (TournamentResult, error) {
+	result := TournamentResult{Seed: seed, Bots: [2]BotRecord{{Name: a}, {Name: b}}}
+	for i := 0; i < n; i++ {
+		attacker, defender := a, b
+		if i%2 == 1 {
+			attacker, defender = b, a
+		}
+		match, err := runMatch(ctx, attacker, defender, seed+uint64(i))
+		if err != nil {
+			return result, fmt.Errorf("match %d: %w", i+1, err)
+		}
+		result.Matches = append(result.Matches, match)
+
+		first, second := &result.Bots[0], &result.Bots[1]
+		if i%2 == 1 {
+			first, second = second, first
+		}
+		switch match.Winner {
+		case WinnerAttacker:
+			first.Wins++
+			second.Losses++
+		case WinnerDefender:
+			first.Losses++
+			second.Wins++
+		default:
+			first.Draws++
+			second.Draws++
+		}
+	}
+	for i := range result.Bots {
+		if n > 0 {
+			result.Bots[i].WinRate = float64(result.Bots[i].Wins) / float64(n)
+		}
+	}
+	return result, nil
+}
+
+func runTournament(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("tournament", flag.ContinueOnError)
+	a := fs.String("a", "orbit", "first bot")
+	b := fs.String("b", "shooter", "second bot")
+	n := fs.Int("n", 10, "number of matches; the bots swap roles every match")
+	seed := fs.Uint64("seed", 1, "seed of the first match")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, name := range []string{*a, *b} {
+		if _, err := newBot(name, nil); err != nil {
+			return err
+		}
+	}
+
+	result, err := playTournament(context.Background(), *a, *b, *n, *seed)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(stdout).Encode(result)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "match\tseed\tattacker\tdefender\twinner\tticks")
+	for i, m := range result.Matches {
+		w := m.WinnerName()
+		if w == "" {
+			w = "draw"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%d\n", i+1, m.Seed, m.Attacker, m.Defender, w, m.Ticks)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "bot\twins\tlosses\tdraws\twin rate")
+	for _, r := range result.Bots {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.0f%%\n", r.Name, r.Wins, r.Losses, r.Draws, 100*r.WinRate)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWinner(t *testing.T) {
+	attacker := ShipState{Ship: Ship{Role: RoleAttacker}}
+	defender := ShipState{Ship: Ship{Role: RoleDefender}}
+	assert.Equal(t, int64(WinnerDefender), winner(&GameState{Ships: []ShipState{attacker, defender}}))
+	assert.Equal(t, int64(WinnerDefender), winner(&GameState{Ships: []ShipState{defender}}))
+	assert.Equal(t, int64(WinnerAttacker), winner(&GameState{Ships: []ShipState{attacker}}))
+	assert.Equal(t, int64(WinnerNone), winner(&GameState{}))
+}
+
+func TestRunMatch(t *testing.T) {
+	result, err := runMatch(context.Background(), "idle", "orbit", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "orbit", result.WinnerName())
+	assert.Positive(t, result.Ticks)
+
+	_, err = runMatch(context.Background(), "idle", "missing", 1)
+	assert.Error(t, err)
+}
+
+func TestTournamentIsReproducible(t *testing.T) {
+	first, err := playTournament(context.Background(), "orbit", "shooter", 4, 7)
+	require.NoError(t, err)
+	second, err := playTournament(context.Background(), "orbit", "shooter", 4, 7)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	require.Len(t, first.Matches, 4)
+	assert.Equal(t, "orbit", first.Matches[0].Attacker)
+	assert.Equal(t, "shooter", first.Matches[1].Attacker)
+	assert.Equal(t, uint64(10), first.Matches[3].Seed)
+	for _, r := range first.Bots {
+		assert.Equal(t, 4, r.Wins+r.Losses+r.Draws)
+		assert.InDelta(t, float64(r.Wins)/4, r.WinRate, 1e-9)
+	}
+}
+
+func TestRunTournament(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, runTournament([]string{"-a", "orbit", "-b", "idle", "-n", "2"}, &out))
+	assert.Contains(t, out.String(), "orbit  2     0       0      100%")
+	assert.Contains(t, out.String(), "idle   0     2       0      0%")
+
+	assert.ErrorContains(t, runTournament([]string{"-a", "clever"}, &out), `unknown bot "clever"`)
+}
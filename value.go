@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Value is the data view of an evaluated expression: galaxy programs build
+// their data from numbers, nil and cons cells. A chain of cons cells ending
+// in nil is a List, any other cons cell a Cons, and expressions that are not
+// data, such as partial applications, are Unevaluated.
+type Value interface {
+	// AsInt returns the number the value holds.
+	AsInt() (int64, error)
+	// AsList returns the elements of a list; nil is the empty list.
+	AsList() ([]Value, error)
+	// AsPair returns the head and tail of a cons cell or non-empty list.
+	AsPair() (head, tail Value, err error)
+	// ToExpr converts the value back into an expression.
+	ToExpr() Expr
+	String() string
+	json.Marshaler
+}
+
+type (
+	Int         int64
+	Nil         struct{}
+	Cons        struct{ Head, Tail Value }
+	List        []Value
+	Unevaluated struct{ Expr Expr }
+)
+
+// ValueError reports a value that doesn't have the shape a caller expects.
+type ValueError struct {
+	Want  string
+	Value Value
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("expected %s, got %s", e.Want, truncate(e.Value.String(), 60))
+}
+
+// toValue interprets an evaluated expression as a value, using the values
+// eval cached in it. It never fails: whatever is not data becomes
+// Unevaluated.
+func toValue(expr Expr) Value {
+	switch e := resolve(expr).(type) {
+	case Number:
+		return Int(e)
+	case Symbol:
+		if e == "nil" {
+			return Nil{}
+		}
+	case *Ap:
+		var items []Value
+		var rest Expr = e
+		for {
+			head, tail, ok := consCell(rest)
+			if !ok {
+				break
+			}
+			items = append(items, toValue(head))
+			rest = tail
+		}
+		if len(items) == 0 {
+			break
+		}
+		if resolve(rest) == Symbol("nil") {
+			return List(items)
+		}
+		result := toValue(rest)
+		for i := len(items) - 1; i >= 0; i-- {
+			result = Cons{Head: items[i], Tail: result}
+		}
+		return result
+	}
+	return Unevaluated{Expr: resolve(expr)}
+}
+
+// consCell splits an "ap ap cons head tail" expression.
+func consCell(expr Expr) (head, tail Expr, ok bool) {
+	if outer, ok := resolve(expr).(*Ap); ok {
+		if inner, ok := resolve(outer.Left).(*Ap); ok && inner.Left == cons {
+			return inner.Right, outer.Right, true
+		}
+	}
+	return nil, nil, false
+}
+
+// isData reports whether v contains no Unevaluated parts.
+func isData(v Value) bool {
+	switch v := v.(type) {
+	case Unevaluated:
+		return false
+	case Cons:
+		return isData(v.Head) && isData(v.Tail)
+	case List:
+		for _, item := range v {
+			if !isData(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (v Int) AsInt() (int64, error)         { return int64(v), nil }
+func (v Int) AsList() ([]Value, error)      { return nil, &ValueError{"a list", v} }
+func (v Int) AsPair() (Value, Value, error) { return nil, nil, &ValueError{"a pair", v} }
+func (v Int) ToExpr() Expr                  { return Number(v) }
+func (v Int) String() string                { return strconv.FormatInt(int64(v), 10) }
+
+func (v Nil) AsInt() (int64, error)         { return 0, &ValueError{"a number", v} }
+func (v Nil) AsList() ([]Value, error)      { return []Value{}, nil }
+func (v Nil) AsPair() (Value, Value, error) { return nil, nil, &ValueError{"a pair", v} }
+func (v Nil) ToExpr() Expr                  { return Symbol("nil") }
+func (v Nil) String() string                { return "[]" }
+
+func (v Cons) AsInt() (int64, error)         { return 0, &ValueError{"a number", v} }
+func (v Cons) AsList() ([]Value, error)      { return nil, &ValueError{"a list", v} }
+func (v Cons) AsPair() (Value, Value, error) { return v.Head, v.Tail, nil }
+func (v Cons) String() string                { return "{" + v.Head.String() + " " + v.Tail.String() + "}" }
+
+func (v List) AsInt() (int64, error)    { return 0, &ValueError{"a number", v} }
+func (v List) AsList() ([]Value, error) { return v, nil }
+
+func (v Unevaluated) AsInt() (int64, error)         { return 0, &ValueError{"a number", v} }
+func (v Unevaluated) AsList() ([]Value, error)      { return nil, &ValueError{"a list", v} }
+func (v Unevaluated) AsPair() (Value, Value, error) { return nil, nil, &ValueError{"a pair", v} }
+func (v Unevaluated) ToExpr() Expr                  { return v.Expr }
+func (v Unevaluated) String() string                { return printExpr(v.Expr) }
+
+func (v Cons) ToExpr() Expr {
+	return &Ap{Left: &Ap{Left: cons, Right: v.Head.ToExpr()}, Right: v.Tail.ToExpr()}
+}
+
+func (v List) AsPair() (Value, Value, error) {
+	switch len(v) {
+	case 0:
+		return nil, nil, &ValueError{"a pair", v}
+	case 1:
+		return v[0], Nil{}, nil
+	}
+	return v[0], v[1:], nil
+}
+
+func (v List) ToExpr() Expr {
+	var result Expr = Symbol("nil")
+	for i := len(v) - 1; i >= 0; i-- {
+		result = &Ap{Left: &Ap{Left: cons, Right: v[i].ToExpr()}, Right: result}
+	}
+	return result
+}
+
+func (v List) String() string {
+	items := make([]string, len(v))
+	for i, item := range v {
+		items[i] = item.String()
+	}
+	return "[" + strings.Join(items, " ") + "]"
+}
+
+// Values are encoded in JSON as objects tagged by "type":
+//
+//	{"type": "int", "value": 42}
+//	{"type": "int", "value": "-9007199254740993"}
+//	{"type": "nil"}
+//	{"type": "list", "items": [<value>, ...]}
+//	{"type": "pair", "head": <value>, "tail": <value>}
+//	{"type": "unevaluated", "expr": "ap add 1"}
+//
+// Numbers outside ±(2^53-1), which JavaScript can't represent exactly, are
+// sent as decimal strings; parseValueJSON accepts either form. Lists are
+// never empty: the empty list is nil.
+
+// maxSafeInt is the largest integer a float64 holds exactly.
+const maxSafeInt = 1<<53 - 1
+
+type jsonValue struct {
+	Type  string            `json:"type"`
+	Value json.RawMessage   `json:"value,omitempty"`
+	Items []json.RawMessage `json:"items,omitempty"`
+	Head  json.RawMessage   `json:"head,omitempty"`
+	Tail  json.RawMessage   `json:"tail,omitempty"`
+	Expr  string            `json:"expr,omitempty"`
+}
+
+func (v Int) MarshalJSON() ([]byte, error) {
+	number := v.String()
+	if v > maxSafeInt || v < -maxSafeInt {
+		number = strconv.Quote(number)
+	}
+	return json.Marshal(jsonValue{Type: "int", Value: json.RawMessage(number)})
+}
+
+func (v Nil) MarshalJSON() ([]byte, error) {
+	return []byte(`{"type":"nil"}`), nil
+}
+
+func (v Cons) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		Head Value  `json:"head"`
+		Tail Value  `json:"tail"`
+	}{"pair", v.Head, v.Tail})
+}
+
+func (v List) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type  string  `json:"type"`
+		Items []Value `json:"items"`
+	}{"list", v})
+}
+
+func (v Unevaluated) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonValue{Type: "unevaluated", Expr: printExpr(v.Expr)})
+}
+
+// parseValueJSON decodes a value in the tagged JSON encoding.
+func parseValueJSON(data []byte) (Value, error) {
+	var jv jsonValue
+	if err := json.Unmarshal(data, &jv); err != nil {
+		return nil, err
+	}
+	switch jv.Type {
+	case "int":
+		var s string
+		if err := json.Unmarshal(jv.Value, &s); err != nil {
+			s = string(jv.Value)
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int %s", jv.Value)
+		}
+		return Int(n), nil
+	case "nil":
+		return Nil{}, nil
+	case "list":
+		if len(jv.Items) == 0 {
+			return nil, errors.New("list without items")
+		}
+		items := make(List, len(jv.Items))
+		for i, item := range jv.Items {
+			v, err := parseValueJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	case "pair":
+		if jv.Head == nil || jv.Tail == nil {
+			return nil, errors.New("pair without head or tail")
+		}
+		head, err := parseValueJSON(jv.Head)
+		if err != nil {
+			return nil, err
+		}
+		tail, err := parseValueJSON(jv.Tail)
+		if err != nil {
+			return nil, err
+		}
+		return Cons{Head: head, Tail: tail}, nil
+	case "unevaluated":
+		expr, err := parseLine(jv.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("unevaluated %q: %w", jv.Expr, err)
+		}
+		return Unevaluated{Expr: expr}, nil
+	default:
+		return nil, fmt.Errorf("unknown value type %q", jv.Type)
+	}
+}
+
+// TaggedValue holds a Value in API types so that it can be decoded from
+// JSON as well as encoded.
+type TaggedValue struct {
+	Value
+}
+
+func (v TaggedValue) MarshalJSON() ([]byte, error) {
+	return v.Value.MarshalJSON()
+}
+
+func (v *TaggedValue) UnmarshalJSON(data []byte) error {
+	value, err := parseValueJSON(data)
+	if err != nil {
+		return err
+	}
+	v.Value = value
+	return nil
+}
+
+// exprFromJSON decodes a value in the tagged JSON encoding into the
+// expression it stands for.
+func exprFromJSON(data []byte) (Expr, error) {
+	v, err := parseValueJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return v.ToExpr(), nil
+}
+
+var (
+	valueType = reflect.TypeFor[Value]()
+	exprType  = reflect.TypeFor[Expr]()
+)
+
+// decodeValue stores v in the Go value out points to, so callers can
+// declare the shape they expect instead of picking values apart. Numbers
+// decode into integers, lists into slices, and lists or pairs into structs
+// whose fields are tagged with the position of their element, as in
+// `galaxy:"0"`. Pointers are left nil for nil and otherwise decode what
+// they point to. Fields of type Value or Expr take the element as is.
+func decodeValue(v Value, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("decodeValue needs a non-nil pointer")
+	}
+	return decodeInto(v, rv.Elem(), "value")
+}
+
+func decodeInto(v Value, rv reflect.Value, path string) error {
+	switch rv.Type() {
+	case valueType:
+		rv.Set(reflect.ValueOf(&v).Elem())
+		return nil
+	case exprType:
+		expr := v.ToExpr()
+		rv.Set(reflect.ValueOf(&expr).Elem())
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if _, ok := v.(Nil); ok {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		ptr := reflect.New(rv.Type().Elem())
+		if err := decodeInto(v, ptr.Elem(), path); err != nil {
+			return err
+		}
+		rv.Set(ptr)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := v.AsInt()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if rv.OverflowInt(n) {
+			return fmt.Errorf("%s: %d overflows %s", path, n, rv.Type())
+		}
+		rv.SetInt(n)
+	case reflect.Slice:
+		items, err := v.AsList()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		slice := reflect.MakeSlice(rv.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeInto(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+	case reflect.Struct:
+		items, err := v.AsList()
+		if pair, ok := v.(Cons); ok {
+			items, err = []Value{pair.Head, pair.Tail}, nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, &ValueError{"a list or pair", v})
+		}
+		for i := 0; i < rv.NumField(); i++ {
+			field := rv.Type().Field(i)
+			tag, ok := field.Tag.Lookup("galaxy")
+			if !ok || !field.IsExported() {
+				continue
+			}
+			index, err := strconv.Atoi(tag)
+			if err != nil {
+				return fmt.Errorf("%s: invalid galaxy tag %q on %s", path, tag, field.Name)
+			}
+			fieldPath := path + "." + field.Name
+			if index < 0 || index >= len(items) {
+				return fmt.Errorf("%s: missing element %d", fieldPath, index)
+			}
+			if err := decodeInto(items[index], rv.Field(i), fieldPath); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: cannot decode into %s", path, rv.Type())
+	}
+	return nil
+}
+
+// valueEncoder is implemented by types that encode themselves differently
+// from what encodeValue would do, such as vectors sent as pairs.
+type valueEncoder interface {
+	galaxyValue() Value
+}
+
+var valueEncoderType = reflect.TypeFor[valueEncoder]()
+
+// encodeValue is the inverse of decodeValue: integers become numbers,
+// slices lists, structs the list of their tagged fields and nil pointers
+// nil. Empty slices and structs are nil, like the empty list.
+func encodeValue(x interface{}) (Value, error) {
+	return encodeFrom(reflect.ValueOf(x))
+}
+
+func encodeFrom(rv reflect.Value) (Value, error) {
+	if !rv.IsValid() {
+		return Nil{}, nil
+	}
+	if rv.Type().Implements(valueEncoderType) {
+		if rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return Nil{}, nil
+		}
+		return rv.Interface().(valueEncoder).galaxyValue(), nil
+	}
+	switch rv.Type() {
+	case valueType:
+		if rv.IsNil() {
+			return Nil{}, nil
+		}
+		return rv.Interface().(Value), nil
+	case exprType:
+		if rv.IsNil() {
+			return Nil{}, nil
+		}
+		return toValue(rv.Interface().(Expr)), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if rv.IsNil() {
+			return Nil{}, nil
+		}
+		return encodeFrom(rv.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(rv.Int()), nil
+	case reflect.Slice:
+		if rv.Len() == 0 {
+			return Nil{}, nil
+		}
+		items := make(List, rv.Len())
+		for i := range items {
+			item, err := encodeFrom(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case reflect.Struct:
+		var items List
+		for i := 0; i < rv.NumField(); i++ {
+			field := rv.Type().Field(i)
+			tag, ok := field.Tag.Lookup("galaxy")
+			if !ok || !field.IsExported() {
+				continue
+			}
+			index, err := strconv.Atoi(tag)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid galaxy tag %q on %s", tag, field.Name)
+			}
+			for len(items) <= index {
+				items = append(items, nil)
+			}
+			if items[index], err = encodeFrom(rv.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		for i, item := range items {
+			if item == nil {
+				return nil, fmt.Errorf("%s has no field for element %d", rv.Type(), i)
+			}
+		}
+		if len(items) == 0 {
+			return Nil{}, nil
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("cannot encode %s", rv.Type())
+	}
+}
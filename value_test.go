@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToValue(t *testing.T) {
+	for _, tt := range []struct {
+		input    string
+		expected Value
+	}{
+		{"5", Int(5)},
+		{"nil", Nil{}},
+		{"ap ap cons 1 nil", List{Int(1)}},
+		{"ap ap cons 1 2", Cons{Int(1), Int(2)}},
+		{"ap ap cons 1 ap ap cons 2 3", Cons{Int(1), Cons{Int(2), Int(3)}}},
+		{"ap ap cons ap ap cons 1 2 ap ap cons nil nil", List{Cons{Int(1), Int(2)}, Nil{}}},
+		{"ap add 1", Unevaluated{&Ap{Left: Symbol("add"), Right: Number(1)}}},
+		{"ap ap cons 1 ap add 1", Cons{Int(1), Unevaluated{&Ap{Left: Symbol("add"), Right: Number(1)}}}},
+	} {
+		expr, err := parseLine(tt.input)
+		require.NoError(t, err)
+		v := toValue(expr)
+		assert.Equal(t, tt.expected, v, tt.input)
+		assert.Equal(t, tt.input, printExpr(v.ToExpr()), tt.input)
+	}
+}
+
+func TestValueAccessors(t *testing.T) {
+	n, err := Int(3).AsInt()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	items, err := Nil{}.AsList()
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	head, tail, err := List{Int(1), Int(2)}.AsPair()
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1), head)
+	assert.Equal(t, List{Int(2)}, tail)
+
+	_, err = Cons{Int(1), Int(2)}.AsList()
+	assert.EqualError(t, err, "expected a list, got {1 2}")
+	_, err = List{Int(1)}.AsInt()
+	assert.EqualError(t, err, "expected a number, got [1]")
+	_, _, err = Unevaluated{Symbol("inc")}.AsPair()
+	var valueErr *ValueError
+	assert.ErrorAs(t, err, &valueErr)
+	assert.Equal(t, "a pair", valueErr.Want)
+}
+
+func TestDecodeValue(t *testing.T) {
+	type result struct {
+		Flag   int64         `galaxy:"0"`
+		State  Value         `galaxy:"1"`
+		Images [][]PointPair `galaxy:"2"`
+		Unused string
+	}
+	expr, err := parseLine("ap ap cons 0 ap ap cons ap ap cons 1 nil ap ap cons ap ap cons ap ap cons ap ap cons 2 3 nil ap ap cons nil nil nil")
+	require.NoError(t, err)
+
+	var r result
+	require.NoError(t, decodeValue(toValue(expr), &r))
+	assert.Equal(t, result{
+		Flag:   0,
+		State:  List{Int(1)},
+		Images: [][]PointPair{{{X: 2, Y: 3}}, {}},
+	}, r)
+
+	expr, err = parseLine("ap ap cons 0 ap ap cons nil ap ap cons ap ap cons ap ap cons 4 nil nil nil")
+	require.NoError(t, err)
+	assert.EqualError(t, decodeValue(toValue(expr), &r), "value.Images[0][0]: expected a list or pair, got 4")
+
+	assert.EqualError(t, decodeValue(List{Int(1)}, &r), "value.State: missing element 1")
+
+	var small int8
+	assert.EqualError(t, decodeValue(Int(300), &small), "value: 300 overflows int8")
+	assert.Error(t, decodeValue(Int(1), small))
+}
+
+func TestEncodeValue(t *testing.T) {
+	type inner struct {
+		A int64 `galaxy:"0"`
+	}
+	type outer struct {
+		N     int64     `galaxy:"0"`
+		Items []inner   `galaxy:"1"`
+		Point PointPair `galaxy:"2"`
+		Opt   *inner    `galaxy:"3"`
+		Skip  string
+	}
+	v, err := encodeValue(outer{N: 1, Items: []inner{{A: 2}}, Point: PointPair{X: 3, Y: 4}})
+	require.NoError(t, err)
+	assert.Equal(t, List{Int(1), List{List{Int(2)}}, List{Int(3), Int(4)}, Nil{}}, v)
+
+	var back outer
+	require.NoError(t, decodeValue(v, &back))
+	assert.Equal(t, outer{N: 1, Items: []inner{{A: 2}}, Point: PointPair{X: 3, Y: 4}}, back)
+
+	_, err = encodeValue(struct {
+		B int64 `galaxy:"1"`
+	}{})
+	assert.Error(t, err)
+}
+
+func TestValueJSON(t *testing.T) {
+	v := List{Int(1), Nil{}, Cons{Int(2), Int(-1 << 60)}, Unevaluated{&Ap{Left: Symbol("add"), Right: Number(1)}}}
+	byts, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "list", "items": [
+		{"type": "int", "value": 1},
+		{"type": "nil"},
+		{"type": "pair", "head": {"type": "int", "value": 2}, "tail": {"type": "int", "value": "-1152921504606846976"}},
+		{"type": "unevaluated", "expr": "ap add 1"}
+	]}`, string(byts))
+
+	decoded, err := parseValueJSON(byts)
+	require.NoError(t, err)
+	assert.Equal(t, v, decoded)
+
+	expr, err := exprFromJSON([]byte(`{"type": "pair", "head": {"type": "int", "value": "7"}, "tail": {"type": "nil"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "ap ap cons 7 nil", printExpr(expr))
+
+	for _, bad := range []string{
+		`{"type": "int", "value": 1.5}`,
+		`{"type": "int"}`,
+		`{"type": "list", "items": []}`,
+		`{"type": "pair", "head": {"type": "nil"}}`,
+		`{"type": "unevaluated", "expr": "ap"}`,
+		`{"type": "string"}`,
+		`[1]`,
+	} {
+		_, err := parseValueJSON([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestInteractStateValue(t *testing.T) {
+	rr := serve(http.MethodPost, "/interact", `{"stateValue": {"type": "nil"}, "point": {"x": 0, "y": 0}}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp InteractResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "ap ap cons 0 ap ap cons ap ap cons 0 nil ap ap cons 0 ap ap cons nil nil", resp.NewState)
+	assert.Equal(t, List{Int(0), List{Int(0)}, Int(0), Nil{}}, resp.NewStateValue.Value)
+
+	rr = serve(http.MethodPost, "/interact", `{"stateValue": {"type": "bogus"}, "point": {"x": 0, "y": 0}}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}